@@ -0,0 +1,183 @@
+package actor
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func collector(name string, options ...Option) (Actor, chan struct{}, *[]int, *sync.Mutex) {
+	release := make(chan struct{})
+	received := &[]int{}
+	mutex := &sync.Mutex{}
+	a := NewActor(name, options...).
+		RegisterHandler("msg", func(_ string, params []interface{}) {
+			<-release
+			mutex.Lock()
+			*received = append(*received, params[0].(int))
+			mutex.Unlock()
+		})
+	return a, release, received, mutex
+}
+
+func TestTrySend(t *testing.T) {
+	a := NewActor("try", Mailbox(2, Block))
+	if err := a.TrySend("msg", 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := a.TrySend("msg", 2); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := a.TrySend("msg", 3); err != MailboxFullError {
+		t.Fatalf("Expected MailboxFullError; received %v", err)
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	for _, test := range []struct {
+		overflow Overflow
+		expected []int
+	}{
+		{DropNewest, []int{1, 2}},
+		{DropOldest, []int{3, 4}},
+	} {
+		a, release, received, mutex := collector("drop", Mailbox(2, test.overflow))
+		for i := 1; i <= 4; i++ {
+			a.Send("msg", i)
+		}
+		a.Start()
+		close(release)
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		if len(*received) != len(test.expected) || (*received)[0] != test.expected[0] || (*received)[1] != test.expected[1] {
+			t.Errorf("Overflow %d: expected %v; received %v", test.overflow, test.expected, *received)
+		}
+		mutex.Unlock()
+	}
+}
+
+func TestBlock(t *testing.T) {
+	a, release, received, mutex := collector("block", Mailbox(1, Block))
+	a.Send("msg", 1)
+	sent := make(chan struct{})
+	go func() {
+		a.Send("msg", 2)
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("Send did not block on a full mailbox")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	a.Start()
+	close(release)
+	<-sent
+	time.Sleep(10 * time.Millisecond)
+
+	mutex.Lock()
+	if len(*received) != 2 {
+		t.Errorf("Expected 2 messages; received %v", *received)
+	}
+	mutex.Unlock()
+}
+
+func TestSupervision(t *testing.T) {
+	for _, strategy := range []Strategy{Resume, Stop} {
+		failures := make(chan *Failure, 1)
+		handled := make(chan int, 2)
+		a := NewActor("supervised", Supervise(func(failure *Failure) Strategy {
+			failures <- failure
+			return strategy
+		})).
+			RegisterHandler("msg", func(_ string, params []interface{}) {
+				if params[0].(int) == 1 {
+					panic("boom")
+				}
+				handled <- params[0].(int)
+			}).
+			Start()
+		a.Send("msg", 1)
+		a.Send("msg", 2)
+
+		failure := <-failures
+		if failure.Reason != "boom" || failure.MessageType != "msg" || len(failure.Stack) == 0 {
+			t.Errorf("Unexpected failure: %#v", failure)
+		}
+
+		select {
+		case n := <-handled:
+			if strategy == Stop {
+				t.Errorf("Stopped actor handled message %d", n)
+			}
+		case <-time.After(20 * time.Millisecond):
+			if strategy == Resume {
+				t.Errorf("Resumed actor did not handle the next message")
+			}
+		}
+	}
+}
+
+type add struct{ n int }
+type total struct{ result chan int }
+
+type counterMessage interface{ counterMessage() }
+
+func (add) counterMessage()   {}
+func (total) counterMessage() {}
+
+func TestTypedActor(t *testing.T) {
+	sum := 0
+	a := NewTypedActor[counterMessage]("counter", func(msg counterMessage) {
+		switch msg := msg.(type) {
+		case add:
+			sum += msg.n
+		case total:
+			msg.result <- sum
+		}
+	}).Start()
+
+	for i := 1; i <= 4; i++ {
+		a.Send(add{i})
+	}
+	result := make(chan int)
+	a.Send(total{result})
+	if n := <-result; n != 10 {
+		t.Fatalf("Expected 10; received %d", n)
+	}
+	a.Stop()
+}
+
+func TestRestart(t *testing.T) {
+	for _, test := range []struct {
+		strategy Strategy
+		expected int
+	}{{Resume, 3}, {Restart, 2}} {
+		a := NewRestartableActor[counterMessage]("counter", func() func(counterMessage) {
+			sum := 0
+			return func(msg counterMessage) {
+				switch msg := msg.(type) {
+				case add:
+					if msg.n < 0 {
+						panic("negative")
+					}
+					sum += msg.n
+				case total:
+					msg.result <- sum
+				}
+			}
+		}, Supervise(func(*Failure) Strategy { return test.strategy })).Start()
+
+		a.Send(add{1})
+		a.Send(add{-1})
+		a.Send(add{2})
+		result := make(chan int)
+		a.Send(total{result})
+		if n := <-result; n != test.expected {
+			t.Errorf("Strategy %d: expected %d; received %d", test.strategy, test.expected, n)
+		}
+		a.Stop()
+	}
+}
@@ -0,0 +1,258 @@
+package actor
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// TypedActor is an actor whose messages are values of type M, all passed to
+// a single handler. Declaring M as an interface implemented by one struct per
+// message kind lets the compiler check every Send and the handler's type switch
+// replaces the params casts of Actor.
+type TypedActor[M any] interface {
+	Start() TypedActor[M]
+	Send(msg M)
+	// TrySend is Send that returns MailboxFullError instead of applying the overflow policy.
+	TrySend(msg M) error
+	Stop()
+}
+
+func NewTypedActor[M any](name string, handler func(M), options ...Option) TypedActor[M] {
+	return newTypedActor(name, handler, options)
+}
+
+// NewRestartableActor is NewTypedActor with a handler made by factory. Every
+// Restart calls factory again, so state captured by the handler starts afresh.
+func NewRestartableActor[M any](name string, factory func() func(M), options ...Option) TypedActor[M] {
+	a := newTypedActor(name, factory(), options)
+	a.factory = factory
+	return a
+}
+
+// MailboxFullError is returned by TrySend when the mailbox is at capacity.
+var MailboxFullError = errors.New("actor mailbox is full")
+
+// Overflow selects what Send does when a bounded mailbox is full.
+type Overflow int
+
+const (
+	// Block waits until the actor takes a message off the mailbox.
+	Block Overflow = iota
+	// DropNewest discards the message being sent.
+	DropNewest
+	// DropOldest discards the oldest pending message to make room.
+	DropOldest
+)
+
+// Strategy selects what a supervised actor does after a handler panics.
+type Strategy int
+
+const (
+	// Escalate re-panics, taking the process down. This is what unsupervised actors do.
+	Escalate Strategy = iota
+	// Resume discards the failed message and carries on with the next one,
+	// keeping whatever state the handler has.
+	Resume
+	// Restart discards the failed message and replaces the handler with a new
+	// one from the factory of a NewRestartableActor. Other actors have no
+	// factory to rebuild their state with, and resume.
+	Restart
+	// Stop stops the actor.
+	Stop
+)
+
+// Failure describes a handler panic. It is also an error.
+type Failure struct {
+	Actor string
+	// MessageType is the message type string of an Actor, or the dynamic type of a TypedActor message.
+	MessageType string
+	// Params holds the params of an Actor message.
+	Params []interface{}
+	// Message holds the message of a TypedActor.
+	Message interface{}
+	Reason  interface{}
+	Stack   []byte
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("actor %s panicked handling '%s': %v", f.Actor, f.MessageType, f.Reason)
+}
+
+// Supervisor is called with every handler panic and picks the strategy to apply.
+type Supervisor func(failure *Failure) Strategy
+
+type Option func(*settings)
+
+type settings struct {
+	capacity   int
+	overflow   Overflow
+	supervisor Supervisor
+}
+
+// Mailbox bounds the number of pending messages. A capacity of zero means unbounded.
+func Mailbox(capacity int, overflow Overflow) Option {
+	return func(s *settings) {
+		s.capacity = capacity
+		s.overflow = overflow
+	}
+}
+
+// Supervise recovers handler panics and hands them to supervisor.
+func Supervise(supervisor Supervisor) Option {
+	return func(s *settings) {
+		s.supervisor = supervisor
+	}
+}
+
+type typedActor[M any] struct {
+	name    string
+	handler func(M)
+	factory func() func(M) // set for restartable actors
+	onStop  func()
+	pending []M
+	*sync.Cond
+	space *sync.Cond
+	settings
+	running bool
+	stopped bool
+}
+
+func newTypedActor[M any](name string, handler func(M), options []Option) *typedActor[M] {
+	mutex := &sync.Mutex{}
+	a := &typedActor[M]{
+		name:    name,
+		handler: handler,
+		Cond:    sync.NewCond(mutex),
+		space:   sync.NewCond(mutex),
+	}
+	for _, option := range options {
+		option(&a.settings)
+	}
+	return a
+}
+
+func (a *typedActor[M]) Start() TypedActor[M] {
+	a.Cond.L.Lock()
+	if !a.running {
+		a.running = true
+		go a.run()
+	}
+	a.Cond.L.Unlock()
+	return a
+}
+
+func (a *typedActor[M]) run() {
+	for {
+		a.Cond.L.Lock()
+
+		if !a.running {
+			a.Cond.L.Unlock()
+			return
+		}
+		if len(a.pending) == 0 {
+			a.Cond.Wait()
+			a.Cond.L.Unlock()
+			continue
+		}
+
+		msg := a.pending[0]
+		a.pending = a.pending[1:]
+		a.space.Signal()
+
+		a.Cond.L.Unlock()
+
+		if stop := a.handle(msg); stop {
+			a.Stop()
+			return
+		}
+	}
+}
+
+// handle runs a single message, recovering a panic if the actor is supervised.
+// It reports whether the supervisor chose to stop the actor.
+func (a *typedActor[M]) handle(msg M) (stop bool) {
+	if a.supervisor != nil {
+		defer func() {
+			if reason := recover(); reason != nil {
+				strategy := a.supervisor(a.failure(msg, reason))
+				if strategy == Escalate {
+					panic(reason)
+				}
+				if strategy == Restart && a.factory != nil {
+					a.handler = a.factory()
+				}
+				stop = strategy == Stop
+			}
+		}()
+	}
+
+	a.handler(msg)
+	return false
+}
+
+func (a *typedActor[M]) failure(msg M, reason interface{}) *Failure {
+	failure := &Failure{
+		Actor:  a.name,
+		Reason: reason,
+		Stack:  debug.Stack(),
+	}
+	if m, ok := any(msg).(message); ok {
+		failure.MessageType = m.messageType
+		failure.Params = m.params
+	} else {
+		failure.MessageType = fmt.Sprintf("%T", msg)
+		failure.Message = msg
+	}
+	return failure
+}
+
+func (a *typedActor[M]) Send(msg M) {
+	a.Cond.L.Lock()
+	defer a.Cond.L.Unlock()
+	if a.full() {
+		switch a.overflow {
+		case Block:
+			for a.full() && !a.stopped {
+				a.space.Wait()
+			}
+		case DropNewest:
+			return
+		case DropOldest:
+			a.pending = a.pending[1:]
+		}
+	}
+	a.pending = append(a.pending, msg)
+	a.Cond.Signal()
+}
+
+func (a *typedActor[M]) TrySend(msg M) error {
+	a.Cond.L.Lock()
+	defer a.Cond.L.Unlock()
+	if a.full() {
+		return MailboxFullError
+	}
+	a.pending = append(a.pending, msg)
+	a.Cond.Signal()
+	return nil
+}
+
+func (a *typedActor[M]) full() bool {
+	return a.capacity > 0 && len(a.pending) >= a.capacity
+}
+
+func (a *typedActor[M]) Stop() {
+	a.Cond.L.Lock()
+	a.running = false
+	a.stopped = true
+	a.space.Broadcast()
+	if a.onStop != nil {
+		a.Cond.L.Unlock()
+		a.onStop()
+		a.Cond.L.Lock()
+	}
+
+	a.Cond.Signal()
+	a.Cond.L.Unlock()
+}
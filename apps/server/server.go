@@ -18,9 +18,10 @@ import (
 var localAddrFlag = flag.String("local", "", "Local address to bind to.")
 var remoteAddrFlag = flag.String("remotes", "", "Comma separated remote addresses.")
 
-const threads = 200
-
-var workers = make(chan struct{}, threads)
+const (
+	threads    = 200
+	queueLimit = 10000
+)
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -30,17 +31,13 @@ func main() {
 	if err != nil {
 		log.Printf("Error: %v", err)
 	}
-	msgr.Subscribe("job", handler)
+	msgr.SubscribeMode("job", "", messenger.Bounded(threads, queueLimit), handler)
 
 	remotes := []string{}
 	if *remoteAddrFlag != "" {
 		remotes = strings.Split(*remoteAddrFlag, ",")
 	}
 
-	for i := 0; i < threads; i++ {
-		workers <- struct{}{}
-	}
-
 	msgr.Join(remotes...)
 
 	sigs := make(chan os.Signal)
@@ -60,15 +57,13 @@ func main() {
 
 var count int64
 
-func handler(topic string, body []byte) []byte {
-	<-workers
+func handler(msg *messenger.Message) ([]byte, error) {
 	c := atomic.AddInt64(&count, 1)
 	if c%1000 == 0 {
 		log.Println(c)
 	}
 	time.Sleep(time.Duration(rand.Intn(100)+100) * time.Millisecond)
-	workers <- struct{}{}
-	return body
+	return msg.Body, nil
 }
 
 func logError(err error) {
@@ -1,77 +1,132 @@
 package future
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 )
 
-type Future interface {
-	Value() interface{}
+// Typed is a future holding a value of type T or an error.
+// The first SetValue or SetError wins; later calls are ignored.
+type Typed[T any] interface {
+	Value() T
 	Error() error
-	SetValue(value interface{})
+	SetValue(value T)
 	SetError(err error)
+
+	// Done returns a channel that is closed once the future is set.
+	Done() <-chan struct{}
+	// IsSet reports whether the future is set, without blocking.
+	IsSet() bool
+	// Wait returns the value and error once the future is set, or the
+	// zero value and context.Cause(ctx) if ctx is done first.
+	Wait(ctx context.Context) (T, error)
+	// WaitTimeout is Wait that gives up with context.DeadlineExceeded after d.
+	WaitTimeout(d time.Duration) (T, error)
 }
 
+// Future is the untyped future.
+type Future = Typed[interface{}]
+
 func NewFuture() Future {
-	return &future{
-		cond: sync.NewCond(&sync.Mutex{}),
+	return NewTyped[interface{}]()
+}
+
+func NewTyped[T any]() Typed[T] {
+	return &future[T]{
+		done: make(chan struct{}),
 	}
 }
 
-type future struct {
-	value interface{}
+// NewCompleted returns a future already set to value.
+func NewCompleted[T any](value T) Typed[T] {
+	f := NewTyped[T]()
+	f.SetValue(value)
+	return f
+}
+
+// NewFailed returns a future already set to err.
+func NewFailed[T any](err error) Typed[T] {
+	f := NewTyped[T]()
+	f.SetError(err)
+	return f
+}
+
+type future[T any] struct {
+	value T
 	err   error
-	cond  *sync.Cond
+	mutex sync.Mutex
+	done  chan struct{}
 	set   bool
 }
 
-func (f *future) Value() interface{} {
-	for {
-		f.cond.L.Lock()
-		if f.set {
-			f.cond.L.Unlock()
-			return f.value
-		}
-		f.cond.Wait()
-		f.cond.L.Unlock()
-	}
+func (f *future[T]) Value() T {
+	<-f.done
+	return f.value
 }
 
-func (f *future) Error() error {
-	for {
-		f.cond.L.Lock()
-		if f.set {
-			f.cond.L.Unlock()
-			return f.err
-		}
-		f.cond.Wait()
-		f.cond.L.Unlock()
-	}
+func (f *future[T]) Error() error {
+	<-f.done
+	return f.err
 }
 
-func (f *future) SetValue(value interface{}) {
-	f.cond.L.Lock()
+func (f *future[T]) SetValue(value T) {
+	f.mutex.Lock()
 	if !f.set {
 		f.value = value
 		f.set = true
-		f.cond.Broadcast()
+		close(f.done)
 	}
-	f.cond.L.Unlock()
+	f.mutex.Unlock()
 }
 
-func (f *future) SetError(err error) {
-	f.cond.L.Lock()
+func (f *future[T]) SetError(err error) {
+	f.mutex.Lock()
 	if !f.set {
 		f.err = err
 		f.set = true
-		f.cond.Broadcast()
+		close(f.done)
 	}
-	f.cond.L.Unlock()
+	f.mutex.Unlock()
+}
+
+func (f *future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *future[T]) IsSet() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *future[T]) Wait(ctx context.Context) (T, error) {
+	// A set future wins over a done context.
+	if f.IsSet() {
+		return f.value, f.err
+	}
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, context.Cause(ctx)
+	}
+}
+
+func (f *future[T]) WaitTimeout(d time.Duration) (T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return f.Wait(ctx)
 }
 
-func (f *future) String() string {
-	f.cond.L.Lock()
-	defer f.cond.L.Unlock()
+func (f *future[T]) String() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
 	if !f.set {
 		return "[future: pending]"
 	} else if f.err != nil {
@@ -0,0 +1,66 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTyped(t *testing.T) {
+	f := NewTyped[[]byte]()
+	go f.SetValue([]byte("Hello"))
+	if string(f.Value()) != "Hello" || f.Error() != nil {
+		t.Fatalf("Expected 'Hello'; received '%s'; err = %v", f.Value(), f.Error())
+	}
+	f.SetValue([]byte("Bye"))
+	if string(f.Value()) != "Hello" {
+		t.Fatalf("Second SetValue overwrote the value: '%s'", f.Value())
+	}
+}
+
+func TestCompleted(t *testing.T) {
+	if f := NewCompleted(42); f.Value() != 42 || f.Error() != nil {
+		t.Fatalf("Expected 42; received %v; err = %v", f.Value(), f.Error())
+	}
+
+	err := errors.New("failed")
+	if f := NewFailed[int](err); f.Value() != 0 || f.Error() != err {
+		t.Fatalf("Expected error %v; received %v; value = %v", err, f.Error(), f.Value())
+	}
+}
+
+func TestUntyped(t *testing.T) {
+	var f Future = NewFuture()
+	f.SetValue("Hello")
+	if f.Value().(string) != "Hello" {
+		t.Fatalf("Expected 'Hello'; received %v", f.Value())
+	}
+}
+
+func TestWait(t *testing.T) {
+	f := NewTyped[int]()
+	if f.IsSet() {
+		t.Fatal("New future is set")
+	}
+	if _, err := f.WaitTimeout(10 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("Expected DeadlineExceeded; received %v", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cause := errors.New("cancelled")
+	cancel(cause)
+	if _, err := f.Wait(ctx); err != cause {
+		t.Fatalf("Expected %v; received %v", cause, err)
+	}
+
+	f.SetValue(42)
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("Done is not closed after SetValue")
+	}
+	if value, err := f.Wait(ctx); value != 42 || err != nil {
+		t.Fatalf("Set future lost to a done context: value = %d; err = %v", value, err)
+	}
+}
@@ -0,0 +1,95 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andrew-suprun/envoy/future"
+	"log"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRequestAsync(t *testing.T) {
+	log.Println("---------------- TestRequestAsync ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	replies := make([]future.Typed[[]byte], 1000)
+	for i := range replies {
+		replies[i], _ = client.RequestAsync(context.Background(), "job", []byte(fmt.Sprintf("Hello %d", i)))
+	}
+	for i, reply := range replies {
+		if err := reply.Error(); err != nil {
+			t.Fatalf("Request %d returned error: %s", i, err)
+		}
+		if body := string(reply.Value()); body != fmt.Sprintf("Hello %d", i) {
+			t.Fatalf("Expected: 'Hello %d'; received '%s'", i, body)
+		}
+	}
+
+	survey, _ := client.SurveyAsync(context.Background(), "job", []byte("Hello"))
+	if bodies := survey.Value(); len(bodies) != 1 || string(bodies[0]) != "Hello" {
+		t.Fatalf("Expected: ['Hello']; received %q; err = %v", bodies, survey.Error())
+	}
+}
+
+func TestPendingAsyncRequests(t *testing.T) {
+	log.Println("---------------- TestPendingAsyncRequests ----------------")
+
+	release := make(chan struct{})
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.SubscribeMode("job", "", Serial(1000), func(msg *Message) ([]byte, error) {
+		<-release
+		return msg.Body, nil
+	})
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	goroutines := runtime.NumGoroutine()
+	replies := make([]future.Typed[[]byte], 500)
+	for i := range replies {
+		replies[i], _ = client.RequestAsync(context.Background(), "job", []byte(fmt.Sprintf("Hello %d", i)))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled, _ := client.RequestAsync(ctx, "job", []byte("Canceled"))
+	time.Sleep(100 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n > goroutines+20 {
+		t.Errorf("%d pending requests run %d goroutines", len(replies), n-goroutines)
+	}
+
+	cancel()
+	if _, err := canceled.WaitTimeout(time.Second); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled; received %v", err)
+	}
+	close(release)
+	for i, reply := range replies {
+		if body, err := reply.Wait(context.Background()); err != nil || string(body) != fmt.Sprintf("Hello %d", i) {
+			t.Fatalf("Expected: 'Hello %d'; received '%s'; err = %v", i, body, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package messenger
+
+import (
+	mRand "math/rand"
+	"sync"
+)
+
+// Balancer picks the server for a Publish or Request among the connected
+// peers subscribed to its topic. Select is called from the messenger's actor,
+// servers is never empty and is ordered by Id.
+type Balancer interface {
+	Select(topic string, servers []Server) Server
+}
+
+// Server is a candidate peer presented to a Balancer.
+type Server interface {
+	Id() string
+	// PendingReplies is the number of messages sent to the server that are still awaiting a reply.
+	PendingReplies() int
+}
+
+// NewRandomBalancer returns the default balancer, which picks a server uniformly at random.
+func NewRandomBalancer() Balancer {
+	return randomBalancer{}
+}
+
+// NewRoundRobinBalancer returns a balancer cycling through the servers of each topic in turn.
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{next: make(map[string]int)}
+}
+
+// NewLeastPendingBalancer returns a balancer picking the server with the fewest pending replies.
+func NewLeastPendingBalancer() Balancer {
+	return leastPendingBalancer{}
+}
+
+// NewPowerOfTwoBalancer returns a balancer picking the less loaded of two random servers.
+func NewPowerOfTwoBalancer() Balancer {
+	return powerOfTwoBalancer{}
+}
+
+// NewWeightedRandomBalancer returns a balancer picking servers at random in proportion
+// to their weights, keyed by host id. Servers without a weight get defaultWeight.
+func NewWeightedRandomBalancer(weights map[string]float64, defaultWeight float64) Balancer {
+	return &weightedRandomBalancer{weights: weights, defaultWeight: defaultWeight}
+}
+
+type randomBalancer struct{}
+
+func (randomBalancer) Select(_ string, servers []Server) Server {
+	return servers[mRand.Intn(len(servers))]
+}
+
+type roundRobinBalancer struct {
+	sync.Mutex
+	next map[string]int
+}
+
+func (b *roundRobinBalancer) Select(topic string, servers []Server) Server {
+	b.Lock()
+	defer b.Unlock()
+	i := b.next[topic] % len(servers)
+	b.next[topic] = i + 1
+	return servers[i]
+}
+
+type leastPendingBalancer struct{}
+
+func (leastPendingBalancer) Select(_ string, servers []Server) Server {
+	var best []Server
+	min := -1
+	for _, server := range servers {
+		pending := server.PendingReplies()
+		if min < 0 || pending < min {
+			min = pending
+			best = best[:0]
+		}
+		if pending == min {
+			best = append(best, server)
+		}
+	}
+	return best[mRand.Intn(len(best))]
+}
+
+type powerOfTwoBalancer struct{}
+
+func (powerOfTwoBalancer) Select(_ string, servers []Server) Server {
+	if len(servers) == 1 {
+		return servers[0]
+	}
+	i := mRand.Intn(len(servers))
+	j := mRand.Intn(len(servers) - 1)
+	if j >= i {
+		j++
+	}
+	if servers[j].PendingReplies() < servers[i].PendingReplies() {
+		return servers[j]
+	}
+	return servers[i]
+}
+
+type weightedRandomBalancer struct {
+	weights       map[string]float64
+	defaultWeight float64
+}
+
+func (b *weightedRandomBalancer) Select(_ string, servers []Server) Server {
+	total := 0.0
+	for _, server := range servers {
+		total += b.weight(server)
+	}
+	if total <= 0 {
+		return servers[mRand.Intn(len(servers))]
+	}
+	r := mRand.Float64() * total
+	for _, server := range servers {
+		r -= b.weight(server)
+		if r < 0 {
+			return server
+		}
+	}
+	return servers[len(servers)-1]
+}
+
+func (b *weightedRandomBalancer) weight(server Server) float64 {
+	if w, found := b.weights[server.Id()]; found && w > 0 {
+		return w
+	} else if found {
+		return 0
+	}
+	return b.defaultWeight
+}
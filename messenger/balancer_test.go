@@ -0,0 +1,61 @@
+package messenger
+
+import (
+	"testing"
+)
+
+type testServer struct {
+	id      string
+	pending int
+}
+
+func (s *testServer) Id() string          { return s.id }
+func (s *testServer) PendingReplies() int { return s.pending }
+
+func testServers() []Server {
+	return []Server{&testServer{"a", 5}, &testServer{"b", 0}, &testServer{"c", 9}}
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	balancer := NewRoundRobinBalancer()
+	servers := testServers()
+	for i := 0; i < 6; i++ {
+		if s := balancer.Select("job", servers); s != servers[i%3] {
+			t.Fatalf("Expected '%s'; selected '%s'", servers[i%3].Id(), s.Id())
+		}
+	}
+	if s := balancer.Select("other", servers); s != servers[0] {
+		t.Fatalf("Topics should be balanced independently; selected '%s'", s.Id())
+	}
+}
+
+func TestLeastPendingBalancer(t *testing.T) {
+	balancer := NewLeastPendingBalancer()
+	for i := 0; i < 10; i++ {
+		if s := balancer.Select("job", testServers()); s.Id() != "b" {
+			t.Fatalf("Expected 'b'; selected '%s'", s.Id())
+		}
+	}
+}
+
+func TestPowerOfTwoBalancer(t *testing.T) {
+	balancer := NewPowerOfTwoBalancer()
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		counts[balancer.Select("job", testServers()).Id()]++
+	}
+	if counts["c"] != 0 || counts["b"] <= counts["a"] {
+		t.Fatalf("Wrong distribution: %v", counts)
+	}
+}
+
+func TestWeightedRandomBalancer(t *testing.T) {
+	balancer := NewWeightedRandomBalancer(map[string]float64{"a": 0, "b": 3}, 1)
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		counts[balancer.Select("job", testServers()).Id()]++
+	}
+	if counts["a"] != 0 || counts["b"] < 2*counts["c"] {
+		t.Fatalf("Wrong distribution: %v", counts)
+	}
+}
@@ -0,0 +1,72 @@
+package messenger
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSurveySubscribedPeersOnly(t *testing.T) {
+	log.Println("---------------- TestSurveySubscribedPeersOnly ----------------")
+
+	opts := Options{Timeout: 5 * time.Second}
+
+	server1, err := NewMessengerWithOptions("localhost:50000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer server1.Leave()
+	server1.Subscribe("job", echo1)
+	server1.Join()
+
+	server2, err := NewMessengerWithOptions("localhost:50001", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer server2.Leave()
+	server2.Subscribe("other", echo2)
+	server2.Join("localhost:50000")
+
+	server3, err := NewMessengerWithOptions("localhost:50002", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer server3.Leave()
+	server3.Subscribe("job", panicingHandler)
+	server3.Join("localhost:50000", "localhost:50001")
+
+	client, err := NewMessengerWithOptions("localhost:40000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Subscribe("job", echo)
+	client.Join("localhost:50000", "localhost:50001", "localhost:50002")
+
+	start := time.Now()
+	replies, _, err := client.Survey("job", []byte("Hello"))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Survey waited for non-subscribed peers: %s", elapsed)
+	}
+	var broadcastErr *BroadcastError
+	if !errors.As(err, &broadcastErr) || len(broadcastErr.Errors) != 1 || broadcastErr.Errors["127.0.0.1:50002"] != PanicError {
+		t.Errorf("Expected a PanicError from 127.0.0.1:50002; received %v", err)
+	}
+	if !errors.Is(err, PanicError) {
+		t.Errorf("BroadcastError does not unwrap to PanicError")
+	}
+	bodies := []string{}
+	for _, reply := range replies {
+		bodies = append(bodies, string(reply))
+	}
+	sort.Strings(bodies)
+	if len(bodies) != 2 || bodies[0] != "Hello" || bodies[1] != "server:1 Hello" {
+		t.Errorf("Expected: ['Hello' 'server:1 Hello']; received %q", bodies)
+	}
+
+	if _, _, err := client.Survey("nobody", []byte("Hello")); err != NoSubscribersError {
+		t.Errorf("Expected NoSubscribersError; received %v", err)
+	}
+}
@@ -2,16 +2,247 @@ package messenger
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/ugorji/go/codec"
 )
 
+// Codec encodes the frames exchanged with peers. Every messenger understands
+// all built-in codecs; the codec of each connection is negotiated when joining.
+// The join handshake itself and the payloads of control messages are always CBOR.
+type Codec interface {
+	// Name identifies the codec during negotiation.
+	Name() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+var (
+	UnsupportedTypeError = errors.New("codec does not support this type")
+	MalformedFrameError  = errors.New("malformed frame")
+)
+
 var ch codec.CborHandle
 
-func encode(v interface{}, buf *bytes.Buffer) {
-	codec.NewEncoder(buf, &ch).MustEncode(v)
+// encode and decode handle CBOR payloads inside messages.
+func encode(h codec.Handle, v interface{}, buf *bytes.Buffer) {
+	codec.NewEncoder(buf, h).MustEncode(v)
+}
+
+func decode(h codec.Handle, buf *bytes.Buffer, v interface{}) error {
+	if err := codec.NewDecoder(buf, h).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", MalformedFrameError, err)
+	}
+	return nil
+}
+
+// builtinCodecs are listed in the order this messenger offers them,
+// after Options.Codec.
+var builtinCodecs = []Codec{NewCBORCodec(), NewMsgpackCodec(), NewBinaryCodec()}
+
+// defaultCodec is used with peers that do not negotiate.
+var defaultCodec = builtinCodecs[0]
+
+type handleCodec struct {
+	name   string
+	handle codec.Handle
+}
+
+func NewCBORCodec() Codec {
+	return handleCodec{name: "cbor", handle: &ch}
+}
+
+func NewMsgpackCodec() Codec {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true // distinguishes []byte from strings
+	return handleCodec{name: "msgpack", handle: h}
+}
+
+func (c handleCodec) Name() string {
+	return c.name
+}
+
+func (c handleCodec) Encode(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, c.handle).Encode(v)
+	return data, err
+}
+
+func (c handleCodec) Decode(data []byte, v interface{}) error {
+	if err := codec.NewDecoderBytes(data, c.handle).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", MalformedFrameError, err)
+	}
+	return nil
+}
+
+// binaryCodec is a hand-written encoding of message: the id, then uvarints
+// for the type and flags, then length-prefixed topic, body, headers, groups
+// and traceparent. It supports no other types.
+type binaryCodec struct{}
+
+func NewBinaryCodec() Codec {
+	return binaryCodec{}
+}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+const (
+	binaryMore = 1 << iota
+	binaryCompressed
+)
+
+func (binaryCodec) Encode(v interface{}) ([]byte, error) {
+	msg, ok := v.(*message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", UnsupportedTypeError, v)
+	}
+	size := messageIdSize + 2*binary.MaxVarintLen64 + 5*binary.MaxVarintLen32 + len(msg.Topic) + len(msg.Body) + len(msg.TraceParent)
+	data := make([]byte, 0, size)
+	data = append(data, msg.MessageId[:]...)
+	data = binary.AppendUvarint(data, uint64(msg.MessageType))
+	var flags uint64
+	if msg.More {
+		flags |= binaryMore
+	}
+	if msg.Compressed {
+		flags |= binaryCompressed
+	}
+	data = binary.AppendUvarint(data, flags)
+	data = appendBytes(data, []byte(msg.Topic))
+	data = appendBytes(data, msg.Body)
+	data = binary.AppendUvarint(data, uint64(len(msg.Headers)))
+	for key, value := range msg.Headers {
+		data = appendBytes(data, []byte(key))
+		data = appendBytes(data, []byte(value))
+	}
+	data = binary.AppendUvarint(data, uint64(len(msg.Groups)))
+	for _, g := range msg.Groups {
+		data = appendBytes(data, []byte(g))
+	}
+	data = appendBytes(data, []byte(msg.TraceParent))
+	return data, nil
+}
+
+func appendBytes(data, b []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(len(b)))
+	return append(data, b...)
+}
+
+func (binaryCodec) Decode(data []byte, v interface{}) error {
+	msg, ok := v.(*message)
+	if !ok {
+		return fmt.Errorf("%w: %T", UnsupportedTypeError, v)
+	}
+	r := &binaryReader{data: data}
+	copy(msg.MessageId[:], r.next(messageIdSize))
+	msg.MessageType = messageType(r.uvarint())
+	flags := r.uvarint()
+	msg.More = flags&binaryMore != 0
+	msg.Compressed = flags&binaryCompressed != 0
+	msg.Topic = topic(r.bytes())
+	if body := r.bytes(); len(body) > 0 {
+		msg.Body = append([]byte(nil), body...)
+	}
+	if n := r.count(); n > 0 {
+		msg.Headers = make(Headers, n)
+		for i := 0; i < n; i++ {
+			key := string(r.bytes())
+			msg.Headers[key] = string(r.bytes())
+		}
+	}
+	if n := r.count(); n > 0 {
+		msg.Groups = make([]group, n)
+		for i := range msg.Groups {
+			msg.Groups[i] = group(r.bytes())
+		}
+	}
+	msg.TraceParent = string(r.bytes())
+	if r.err != nil || len(r.data) > 0 {
+		return MalformedFrameError
+	}
+	return nil
+}
+
+// binaryReader consumes data, recording the first error and returning zero values after it.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) next(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.err = MalformedFrameError
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = MalformedFrameError
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// count reads a number of elements, each at least one byte long.
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.err = MalformedFrameError
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) bytes() []byte {
+	return r.next(r.count())
+}
+
+// supportedCodecs lists the codecs of msgr in the order they are offered to peers.
+func (msgr *messenger) supportedCodecs() []Codec {
+	codecs := []Codec{msgr.Codec}
+	for _, c := range builtinCodecs {
+		if c.Name() != msgr.Codec.Name() {
+			codecs = append(codecs, c)
+		}
+	}
+	return codecs
+}
+
+func (msgr *messenger) codecNames() []string {
+	var names []string
+	for _, c := range msgr.supportedCodecs() {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// chooseCodec picks the first codec offered by a dialing peer that msgr supports.
+func (msgr *messenger) chooseCodec(offered []string) Codec {
+	for _, name := range offered {
+		if c := msgr.codecByName(name); c != nil {
+			return c
+		}
+	}
+	return defaultCodec
 }
 
-func decode(buf *bytes.Buffer, v interface{}) {
-	dec := codec.NewDecoder(buf, &ch)
-	dec.MustDecode(v)
+func (msgr *messenger) codecByName(name string) Codec {
+	for _, c := range msgr.supportedCodecs() {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
 }
@@ -0,0 +1,89 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	msg := &message{
+		MessageId:   newId(),
+		MessageType: request,
+		Topic:       "job",
+		Body:        []byte("Hello"),
+		Headers:     Headers{"a": "1", "b": ""},
+		Groups:      []group{"workers", "auditors"},
+		TraceParent: NewSpanContext(SpanContext{}).String(),
+		More:        true,
+		Compressed:  true,
+	}
+	for _, c := range builtinCodecs {
+		data, err := c.Encode(msg)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", c.Name(), err)
+		}
+		decoded := &message{}
+		if err := c.Decode(data, decoded); err != nil {
+			t.Fatalf("%s: failed to decode: %v", c.Name(), err)
+		}
+		if !reflect.DeepEqual(msg, decoded) {
+			t.Errorf("%s: expected %+v; received %+v", c.Name(), msg, decoded)
+		}
+	}
+}
+
+func TestBinaryCodecErrors(t *testing.T) {
+	c := NewBinaryCodec()
+	if _, err := c.Encode("hello"); !errors.Is(err, UnsupportedTypeError) {
+		t.Errorf("Expected UnsupportedTypeError; received %v", err)
+	}
+	data, _ := c.Encode(&message{MessageId: newId(), MessageType: publish, Topic: "job", Body: []byte("Hello")})
+	for i := 0; i < len(data); i++ {
+		if err := c.Decode(data[:i], &message{}); !errors.Is(err, MalformedFrameError) {
+			t.Fatalf("Expected MalformedFrameError for %d of %d bytes; received %v", i, len(data), err)
+		}
+	}
+}
+
+func TestChooseCodec(t *testing.T) {
+	msgr := &messenger{Options: Options{Codec: NewMsgpackCodec()}}
+	if names := msgr.codecNames(); !reflect.DeepEqual(names, []string{"msgpack", "cbor", "binary"}) {
+		t.Errorf("Unexpected codec offer %v", names)
+	}
+	if c := msgr.chooseCodec([]string{"json", "binary", "msgpack"}); c.Name() != "binary" {
+		t.Errorf("Expected binary; chose %s", c.Name())
+	}
+	if c := msgr.chooseCodec(nil); c != defaultCodec {
+		t.Errorf("Expected default codec for a legacy peer; chose %s", c.Name())
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	log.Println("---------------- TestCodecNegotiation ----------------")
+
+	server, err := NewMessengerWithOptions("localhost:50000", Options{Codec: NewMsgpackCodec()})
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	client, err := NewMessengerWithOptions("localhost:40000", Options{Codec: NewBinaryCodec()})
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	for i := 0; i < 10; i++ {
+		body := fmt.Sprintf("Hello %d", i)
+		reply, _, err := client.Request("job", []byte(body))
+		if err != nil || string(reply) != body {
+			t.Fatalf("Expected '%s'; received '%s'; err = %v", body, reply, err)
+		}
+	}
+}
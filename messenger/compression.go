@@ -0,0 +1,135 @@
+package messenger
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compressor compresses message bodies on the wire. Messengers offer their
+// Options.Compressors by name when joining; a connection uses the first
+// compressor offered by the dialer that the acceptor also has, or none.
+type Compressor interface {
+	// Name identifies the compressor during negotiation.
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails with FrameTooLargeError rather than return more than
+	// maxSize bytes, so that a small frame cannot inflate into a huge body.
+	Decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+var UnsupportedCompressionError = errors.New("compressed frame on a connection without compression")
+
+const defaultCompressionThreshold = 1024
+
+type flateCompressor struct {
+	level int
+}
+
+// NewFlateCompressor returns a raw DEFLATE compressor; level is one of the compress/flate levels.
+func NewFlateCompressor(level int) Compressor {
+	return flateCompressor{level: level}
+}
+
+func (flateCompressor) Name() string {
+	return "flate"
+}
+
+func (c flateCompressor) Compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	return compress(w, buf, data)
+}
+
+func (flateCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return decompress(r, maxSize)
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// NewGzipCompressor returns a gzip compressor; level is one of the compress/gzip levels.
+func NewGzipCompressor(level int) Compressor {
+	return gzipCompressor{level: level}
+}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (c gzipCompressor) Compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	return compress(w, buf, data)
+}
+
+func (gzipCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return decompress(r, maxSize)
+}
+
+func compress(w io.WriteCloser, buf *bytes.Buffer, data []byte) ([]byte, error) {
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress reads r up to maxSize bytes.
+func decompress(r io.Reader, maxSize int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("%w: body decompresses to more than %d bytes", FrameTooLargeError, maxSize)
+	}
+	return data, nil
+}
+
+func (msgr *messenger) compressorNames() []string {
+	var names []string
+	for _, c := range msgr.Compressors {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// chooseCompressor picks the first compressor offered by a dialing peer that msgr has.
+// It returns nil when there is none, and the connection stays uncompressed.
+func (msgr *messenger) chooseCompressor(offered []string) Compressor {
+	for _, name := range offered {
+		if c := msgr.compressorByName(name); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+func (msgr *messenger) compressorByName(name string) Compressor {
+	for _, c := range msgr.Compressors {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
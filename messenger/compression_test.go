@@ -0,0 +1,110 @@
+package messenger
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"log"
+	"net"
+	"testing"
+)
+
+var jsonBody = bytes.Repeat([]byte(`{"id": 12345, "name": "envoy", "tags": ["a", "b", "c"]},`), 100)
+
+func TestCompressors(t *testing.T) {
+	for _, c := range []Compressor{NewFlateCompressor(flate.BestSpeed), NewGzipCompressor(gzip.DefaultCompression)} {
+		compressed, err := c.Compress(jsonBody)
+		if err != nil {
+			t.Fatalf("%s: failed to compress: %v", c.Name(), err)
+		}
+		if len(compressed) >= len(jsonBody) {
+			t.Errorf("%s: expected fewer than %d bytes; received %d", c.Name(), len(jsonBody), len(compressed))
+		}
+		body, err := c.Decompress(compressed, len(jsonBody))
+		if err != nil || !bytes.Equal(body, jsonBody) {
+			t.Errorf("%s: round trip failed; err = %v", c.Name(), err)
+		}
+		if _, err := c.Decompress(compressed, len(jsonBody)-1); !errors.Is(err, FrameTooLargeError) {
+			t.Errorf("%s: expected FrameTooLargeError; received %v", c.Name(), err)
+		}
+	}
+}
+
+func TestCompressedFrames(t *testing.T) {
+	opts := Options{Log: Log, CompressionThreshold: 1024, MaxFrameSize: defaultMaxFrameSize}
+	writerConn, readerConn := net.Pipe()
+	defer writerConn.Close()
+	defer readerConn.Close()
+	w := &writer{Conn: writerConn, codec: defaultCodec, compressor: NewGzipCompressor(gzip.BestSpeed), Options: &opts}
+	r := &reader{Conn: readerConn, codec: defaultCodec, compressor: NewGzipCompressor(gzip.BestSpeed), Options: &opts}
+
+	for _, body := range [][]byte{[]byte("small"), jsonBody} {
+		go w.writeFrame(&message{MessageId: newId(), MessageType: publish, Topic: "job", Body: body})
+		msg, err := readMessage(r.Conn, r.codec, defaultMaxFrameSize)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if msg.Compressed != (len(body) >= opts.CompressionThreshold) {
+			t.Errorf("Body of %d bytes: compressed = %v", len(body), msg.Compressed)
+		}
+		if err := r.decompress(msg); err != nil || !bytes.Equal(msg.Body, body) || msg.Compressed {
+			t.Errorf("Body of %d bytes not restored; err = %v", len(body), err)
+		}
+	}
+
+	r.compressor = nil
+	go w.writeFrame(&message{MessageId: newId(), MessageType: publish, Topic: "job", Body: jsonBody})
+	msg, _ := readMessage(r.Conn, r.codec, defaultMaxFrameSize)
+	if err := r.decompress(msg); !errors.Is(err, UnsupportedCompressionError) {
+		t.Errorf("Expected UnsupportedCompressionError; received %v", err)
+	}
+}
+
+func TestChooseCompressor(t *testing.T) {
+	msgr := &messenger{Options: Options{Compressors: []Compressor{NewGzipCompressor(gzip.DefaultCompression), NewFlateCompressor(flate.DefaultCompression)}}}
+	if c := msgr.chooseCompressor([]string{"zstd", "flate", "gzip"}); c == nil || c.Name() != "flate" {
+		t.Errorf("Expected flate; chose %v", c)
+	}
+	if c := msgr.chooseCompressor(nil); c != nil {
+		t.Errorf("Expected no compression for a peer without compressors; chose %s", c.Name())
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	log.Println("---------------- TestCompressionNegotiation ----------------")
+
+	server, err := NewMessengerWithOptions("localhost:50000", Options{
+		Compressors: []Compressor{NewGzipCompressor(gzip.DefaultCompression), NewFlateCompressor(flate.DefaultCompression)},
+		PartSize:    1024,
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	client1, err := NewMessengerWithOptions("localhost:40000", Options{Compressors: []Compressor{NewFlateCompressor(flate.BestSpeed)}, PartSize: 1024})
+	if err != nil {
+		t.FailNow()
+	}
+	defer client1.Leave()
+	client1.Join("localhost:50000")
+
+	client2, err := NewMessenger("localhost:40001")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client2.Leave()
+	client2.Join("localhost:50000")
+
+	for _, client := range []Messenger{client1, client2} {
+		for _, body := range [][]byte{[]byte("Hello"), jsonBody} {
+			reply, _, err := client.Request("job", body)
+			if err != nil || !bytes.Equal(reply, body) {
+				t.Fatalf("Expected a reply of %d bytes; received %d; err = %v", len(body), len(reply), err)
+			}
+		}
+	}
+}
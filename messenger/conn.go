@@ -2,14 +2,24 @@ package messenger
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
 )
 
-var readMessage func(net.Conn) (*message, error) = _readMessage
+var FrameTooLargeError = errors.New("frame too large")
 
-// todo: add timeout handling
-func _readMessage(from net.Conn) (*message, error) {
+// MessageTooLargeError fails a send whose body is above Options.MaxMessageSize,
+// or above the limit the receiving peer advertised when joining. Nothing is written.
+var MessageTooLargeError = errors.New("message too large")
+
+var readMessage func(net.Conn, Codec, int) (*message, error) = _readMessage
+
+// _readMessage reads a frame of at most maxSize bytes and decodes it with c.
+// Callers set read deadlines on the connection.
+func _readMessage(from net.Conn, c Codec, maxSize int) (*message, error) {
 	if from == nil {
 		return nil, NilConnError
 	}
@@ -25,6 +35,9 @@ func _readMessage(from net.Conn) (*message, error) {
 	}
 
 	msgSize := getUint32(lenBuf)
+	if uint64(msgSize) > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes from %s, limit %d", FrameTooLargeError, msgSize, from.RemoteAddr(), maxSize)
+	}
 	msgBytes := make([]byte, msgSize)
 	readBuf = msgBytes
 	for len(readBuf) > 0 {
@@ -34,18 +47,24 @@ func _readMessage(from net.Conn) (*message, error) {
 		}
 		readBuf = readBuf[n:]
 	}
-	msgBuf := bytes.NewBuffer(msgBytes)
 	msg := &message{}
-	decode(msgBuf, msg)
+	if err := c.Decode(msgBytes, msg); err != nil {
+		return nil, err
+	}
 	return msg, nil
 }
 
-var writeMessage func(net.Conn, *message) error = _writeMessage
+var writeMessage func(net.Conn, *message, Codec) error = _writeMessage
 
-// todo: add timeout handling
-func _writeMessage(to net.Conn, msg *message) error {
-	buf := bytes.NewBuffer(make([]byte, 4, 128))
-	encode(msg, buf)
+// _writeMessage encodes msg with c and writes it as a single frame.
+// Callers set write deadlines on the connection.
+func _writeMessage(to net.Conn, msg *message, c Codec) error {
+	data, err := c.Encode(msg)
+	if err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(make([]byte, 4, 4+len(data)))
+	buf.Write(data)
 	bufSize := buf.Len()
 	putUint32(buf.Bytes(), uint32(bufSize-4))
 	n, err := to.Write(buf.Bytes())
@@ -55,6 +74,14 @@ func _writeMessage(to net.Conn, msg *message) error {
 	return err
 }
 
+// peerCertificates returns the certificates presented by the remote side of a TLS connection.
+func peerCertificates(conn net.Conn) []*x509.Certificate {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState().PeerCertificates
+	}
+	return nil
+}
+
 func getUint32(b []byte) uint32 {
 	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
 }
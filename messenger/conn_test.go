@@ -0,0 +1,154 @@
+package messenger
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+// frameConn serves reads from a byte slice.
+type frameConn struct {
+	net.Conn
+	io.Reader
+}
+
+func (conn frameConn) Read(b []byte) (int, error) {
+	return conn.Reader.Read(b)
+}
+
+func (conn frameConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+}
+
+func frame(data []byte) []byte {
+	buf := make([]byte, 4, 4+len(data))
+	putUint32(buf, uint32(len(data)))
+	return append(buf, data...)
+}
+
+func TestFrameLimits(t *testing.T) {
+	data, _ := defaultCodec.Encode(&message{MessageId: newId(), MessageType: publish, Topic: "job", Body: []byte("Hello")})
+	if _, err := readMessage(frameConn{Reader: bytes.NewReader(frame(data))}, defaultCodec, len(data)); err != nil {
+		t.Errorf("Frame at the limit was refused: %v", err)
+	}
+	if _, err := readMessage(frameConn{Reader: bytes.NewReader(frame(data))}, defaultCodec, len(data)-1); !errors.Is(err, FrameTooLargeError) {
+		t.Errorf("Expected FrameTooLargeError; received %v", err)
+	}
+	if _, err := readMessage(frameConn{Reader: bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})}, defaultCodec, defaultMaxFrameSize); !errors.Is(err, FrameTooLargeError) {
+		t.Errorf("Expected FrameTooLargeError for a 4GB frame; received %v", err)
+	}
+	for _, c := range builtinCodecs {
+		if _, err := readMessage(frameConn{Reader: bytes.NewReader(frame([]byte{0xff, 0x01}))}, c, defaultMaxFrameSize); !errors.Is(err, MalformedFrameError) {
+			t.Errorf("%s: expected MalformedFrameError; received %v", c.Name(), err)
+		}
+	}
+}
+
+func FuzzReadMessage(f *testing.F) {
+	msg := &message{
+		MessageId:   newId(),
+		MessageType: request,
+		Topic:       "job",
+		Body:        []byte("Hello"),
+		Headers:     Headers{"a": "1"},
+		Groups:      []group{"workers"},
+		TraceParent: NewSpanContext(SpanContext{}).String(),
+	}
+	for i, c := range builtinCodecs {
+		data, _ := c.Encode(msg)
+		f.Add(uint8(i), frame(data))
+	}
+	f.Add(uint8(0), []byte{0xff, 0xff, 0xff, 0xff})
+	// A binary frame past id, type, flags, topic and body, claiming billions of headers.
+	hugeCount := append(make([]byte, messageIdSize), byte(request), 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0x0f)
+	f.Add(uint8(2), frame(hugeCount))
+
+	f.Fuzz(func(t *testing.T, codecIndex uint8, data []byte) {
+		c := builtinCodecs[int(codecIndex)%len(builtinCodecs)]
+		msg, err := readMessage(frameConn{Reader: bytes.NewReader(data)}, c, 1024)
+		if err != nil {
+			return
+		}
+		// Whatever decodes must encode again.
+		if _, err := c.Encode(msg); err != nil {
+			t.Fatalf("%s: decoded message does not encode: %v", c.Name(), err)
+		}
+	})
+}
+
+func TestStrayClient(t *testing.T) {
+	log.Println("---------------- TestStrayClient ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	unknown, _ := defaultCodec.Encode(&message{MessageId: newId(), MessageType: messageType(99)})
+	for _, garbage := range [][]byte{{0xff, 0xff, 0xff, 0xff}, frame([]byte("not a join message")), frame(unknown)} {
+		conn, err := net.Dial("tcp", "localhost:50000")
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		conn.Write(garbage)
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Errorf("Stray client was not disconnected")
+		}
+		conn.Close()
+	}
+
+	silent, err := net.Dial("tcp", "localhost:50000")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer silent.Close()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	start := time.Now()
+	client.Join("localhost:50000")
+	if reply, _, err := client.Request("job", []byte("Hello")); err != nil || string(reply) != "Hello" {
+		t.Fatalf("Expected 'Hello' after stray clients; received '%s'; err = %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("A silent client held up joining for %v", elapsed)
+	}
+}
+
+func TestReassemblyLimits(t *testing.T) {
+	r := &reader{hostId: "peer", parts: make(map[messageId]*message), Options: &Options{MaxMessageSize: 10}}
+	id := newId()
+	if msg, err := r.assemble(&message{MessageId: id, Body: []byte("12345"), More: true}); msg != nil || err != nil {
+		t.Fatalf("Expected the first part to be kept; received %v; err = %v", msg, err)
+	}
+	if msg, err := r.assemble(&message{MessageId: id, Body: []byte("12345")}); err != nil || string(msg.Body) != "1234512345" {
+		t.Fatalf("Expected a message at the limit; received %v; err = %v", msg, err)
+	}
+	r.assemble(&message{MessageId: id, Body: []byte("12345"), More: true})
+	if _, err := r.assemble(&message{MessageId: id, Body: []byte("123456")}); !errors.Is(err, FrameTooLargeError) {
+		t.Errorf("Expected FrameTooLargeError for a message above the limit; received %v", err)
+	}
+	if _, err := r.assemble(&message{MessageId: newId(), Body: []byte("12345678901")}); !errors.Is(err, FrameTooLargeError) {
+		t.Errorf("Expected FrameTooLargeError for a single frame above the limit; received %v", err)
+	}
+
+	r = &reader{hostId: "peer", parts: make(map[messageId]*message), Options: &Options{MaxMessageSize: 10}}
+	for i := 0; i < maxPartialMessages; i++ {
+		if _, err := r.assemble(&message{MessageId: newId(), Body: []byte("1"), More: true}); err != nil {
+			t.Fatalf("Message %d in parts refused: %v", i, err)
+		}
+	}
+	if _, err := r.assemble(&message{MessageId: newId(), Body: []byte("1"), More: true}); !errors.Is(err, MalformedFrameError) {
+		t.Errorf("Expected MalformedFrameError for too many messages in parts; received %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestContextDeadline(t *testing.T) {
+	log.Println("---------------- TestRequestContextDeadline ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", sleepy)
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = client.RequestContext(ctx, "job", []byte("Hello"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded; received %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Request was not cancelled promptly: %s", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, _, err = client.RequestContext(ctx, "job", []byte("Hello"))
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled; received %v", err)
+	}
+
+	reply, _, err := client.RequestContext(context.Background(), "job", []byte("Hello"))
+	if err != nil || string(reply) != "Hello" {
+		t.Fatalf("Expected: 'Hello'; received '%s', err = %v", string(reply), err)
+	}
+}
+
+func TestCanceledContextSendsNothing(t *testing.T) {
+	log.Println("---------------- TestCanceledContextSendsNothing ----------------")
+
+	var handled atomic.Int32
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", func(topic string, body []byte) []byte {
+		handled.Add(1)
+		return body
+	})
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := client.RequestContext(ctx, "job", []byte("Hello")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Request; received %v", err)
+	}
+	if _, err := client.PublishContext(ctx, "job", []byte("Hello")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Publish; received %v", err)
+	}
+	if _, _, err := client.SurveyContext(ctx, "job", []byte("Hello")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Survey; received %v", err)
+	}
+	if _, _, err := client.Request("job", []byte("Hello")); err != nil {
+		t.Fatalf("Request returned error: %v", err)
+	}
+	if n := handled.Load(); n != 1 {
+		t.Errorf("Expected the handler to run once; ran %d times", n)
+	}
+}
+
+func TestTimeoutErrorIsDeadlineExceeded(t *testing.T) {
+	if !errors.Is(TimeoutError, context.DeadlineExceeded) {
+		t.Fatalf("TimeoutError does not match context.DeadlineExceeded")
+	}
+}
+
+func sleepy(topic string, body []byte) []byte {
+	time.Sleep(500 * time.Millisecond)
+	return body
+}
@@ -2,37 +2,41 @@ package messenger
 
 import (
 	"bytes"
+	"crypto/tls"
 	"github.com/andrew-suprun/envoy/actor"
 	"github.com/andrew-suprun/envoy/future"
 	"net"
+	"time"
 )
 
 type dialer struct {
 	name string
-	actor.Actor
-	msgr actor.Actor
+	actor.TypedActor[dialRequest]
+	msgr actor.TypedActor[msgrEvent]
+	*Options
 }
 
-func newDialer(name string, msgr actor.Actor) actor.Actor {
+type dialRequest struct {
+	peerId  hostId
+	joinMsg *joinMessage
+	result  future.Typed[bool] // optional
+}
+
+func newDialer(name string, msgr actor.TypedActor[msgrEvent], supervisor actor.Supervisor, opts *Options) actor.TypedActor[dialRequest] {
 	dialer := &dialer{
-		name:  name,
-		Actor: actor.NewActor(name),
-		msgr:  msgr,
+		name:    name,
+		msgr:    msgr,
+		Options: opts,
 	}
-	dialer.RegisterHandler("dial", dialer.handleDial).Start()
+	dialer.TypedActor = actor.NewTypedActor(name, dialer.handleDial, actor.Supervise(supervisor)).Start()
 	return dialer
 }
 
-func (dialer *dialer) handleDial(_ string, info []interface{}) {
-	addr := info[0].(hostId)
-	joinMsg := info[1].(*joinMessage)
-	var result future.Future
-	if len(info) > 2 && info[2] != nil {
-		result = info[2].(future.Future)
-	}
+func (dialer *dialer) handleDial(req dialRequest) {
+	addr, joinMsg, result := req.peerId, req.joinMsg, req.result
 
 	buf := &bytes.Buffer{}
-	encode(joinMsg, buf)
+	encode(&ch, joinMsg, buf)
 	msg := &message{
 		MessageId:   newId(),
 		MessageType: join,
@@ -43,39 +47,54 @@ func (dialer *dialer) handleDial(_ string, info []interface{}) {
 		defer result.SetValue(true)
 	}
 
-	conn, err := net.Dial("tcp", string(addr))
+	conn, err := dialer.dial(addr)
 	if err != nil {
 		dialer.reportDialError(addr, result, err)
 		return
 	}
 
-	err = writeMessage(conn, msg)
+	conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	err = writeMessage(conn, msg, defaultCodec)
 	if err != nil {
+		conn.Close()
 		dialer.reportDialError(addr, result, err)
 		return
 	}
 
-	replyMsg, err := readMessage(conn)
+	replyMsg, err := readMessage(conn, defaultCodec, dialer.MaxFrameSize)
 	if err != nil {
+		conn.Close()
 		dialer.reportDialError(addr, result, err)
 		return
 	}
 
 	buf = bytes.NewBuffer(replyMsg.Body)
 	reply := &joinMessage{}
-	decode(buf, reply)
+	if err := decode(&ch, buf, reply); err != nil {
+		conn.Close()
+		dialer.reportDialError(addr, result, err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
 
-	dialer.msgr.Send("dialed", conn, reply, result)
+	dialer.msgr.Send(connectedEvent{conn: conn, joinMsg: reply})
+}
 
-	return
+func (dialer *dialer) dial(addr hostId) (net.Conn, error) {
+	netDialer := &net.Dialer{Timeout: dialer.Timeout}
+	if dialer.TLSConfig == nil {
+		return netDialer.Dial("tcp", string(addr))
+	}
+	return tls.DialWithDialer(netDialer, "tcp", string(addr), dialer.TLSConfig)
 }
-func (dialer *dialer) reportDialError(peerId hostId, result future.Future, err error) {
+
+func (dialer *dialer) reportDialError(peerId hostId, result future.Typed[bool], err error) {
 	if result != nil {
 		result.SetError(err)
 	}
-	dialer.msgr.Send("dial-error", peerId)
+	dialer.msgr.Send(dialErrorEvent{peerId: peerId})
 }
 
 func (dialer *dialer) logf(format string, params ...interface{}) {
-	Log.Debugf(">>> %s: "+format, append([]interface{}{dialer.name}, params...)...)
+	dialer.Log.Debugf(">>> %s: "+format, append([]interface{}{dialer.name}, params...)...)
 }
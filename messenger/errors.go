@@ -0,0 +1,63 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RemoteError is returned to a requester when the remote handler fails with an error.
+// Handlers may return a *RemoteError themselves (see NewRemoteError) to set a Code.
+type RemoteError struct {
+	Code    string `codec:"c,omitempty"`
+	Message string `codec:"m,omitempty"`
+}
+
+func NewRemoteError(code, message string) *RemoteError {
+	return &RemoteError{Code: code, Message: message}
+}
+
+func (err *RemoteError) Error() string {
+	if err.Code == "" {
+		return err.Message
+	}
+	return fmt.Sprintf("%s: %s", err.Code, err.Message)
+}
+
+// BroadcastError is returned by Broadcast and Survey when some of the subscribed
+// peers failed. Errors is keyed by peer host id; replies of the other peers are
+// still returned. errors.Is and errors.As look through the individual errors.
+type BroadcastError struct {
+	Errors map[string]error
+}
+
+func (err *BroadcastError) Error() string {
+	peers := make([]string, 0, len(err.Errors))
+	for peer := range err.Errors {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	msgs := make([]string, len(peers))
+	for i, peer := range peers {
+		msgs[i] = fmt.Sprintf("%s: %v", peer, err.Errors[peer])
+	}
+	return fmt.Sprintf("%d peer(s) failed: %s", len(peers), strings.Join(msgs, "; "))
+}
+
+func (err *BroadcastError) Unwrap() []error {
+	errs := make([]error, 0, len(err.Errors))
+	for _, e := range err.Errors {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// toRemoteError converts a handler's error into the form sent back to requesters.
+func toRemoteError(err error) *RemoteError {
+	remoteErr := &RemoteError{}
+	if !errors.As(err, &remoteErr) {
+		remoteErr = &RemoteError{Message: err.Error()}
+	}
+	return remoteErr
+}
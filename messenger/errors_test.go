@@ -0,0 +1,50 @@
+package messenger
+
+import (
+	"errors"
+	"log"
+	"testing"
+)
+
+func TestRemoteError(t *testing.T) {
+	log.Println("---------------- TestRemoteError ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.SubscribeMessages("job", "", func(msg *Message) ([]byte, error) {
+		switch string(msg.Body) {
+		case "missing":
+			return nil, NewRemoteError("not_found", "no such order")
+		case "broken":
+			return nil, testError
+		}
+		return msg.Body, nil
+	})
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	_, _, err = client.Request("job", []byte("missing"))
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Code != "not_found" || remoteErr.Message != "no such order" {
+		t.Fatalf("Expected not_found RemoteError; received %#v", err)
+	}
+
+	_, _, err = client.Request("job", []byte("broken"))
+	if !errors.As(err, &remoteErr) || remoteErr.Code != "" || remoteErr.Message != testError.Error() {
+		t.Fatalf("Expected RemoteError '%s'; received %#v", testError, err)
+	}
+
+	reply, _, err := client.Request("job", []byte("Hello"))
+	if err != nil || string(reply) != "Hello" {
+		t.Fatalf("Expected: 'Hello'; received '%s'; err = %v", string(reply), err)
+	}
+}
@@ -0,0 +1,124 @@
+package messenger
+
+import (
+	"github.com/andrew-suprun/envoy/future"
+	"net"
+)
+
+// msgrEvent is a message handled by the messenger actor.
+type msgrEvent interface {
+	msgrEvent()
+}
+
+type dialEvent struct {
+	peerId hostId
+	result future.Typed[bool] // optional; set once the peer is joined
+}
+
+type connectedEvent struct {
+	conn     net.Conn
+	joinMsg  *joinMessage
+	accepted bool // accepted by the listener rather than dialed
+}
+
+type writeResultEvent struct {
+	peerId hostId
+	msg    *message
+	err    error
+}
+
+type sendMessageEvent struct {
+	msg  *message
+	key  string
+	done func(*message, error)
+}
+
+type broadcastMessageEvent struct {
+	msg    *message
+	survey *survey
+}
+
+// subscribeEvent subscribes to topic in group; a nil sub unsubscribes.
+type subscribeEvent struct {
+	group group
+	topic topic
+	sub   *subscription
+}
+
+type leaveEvent struct {
+	result future.Typed[bool] // set once every peer is shut down
+}
+
+type cancelMessageEvent struct {
+	msgId messageId
+}
+
+type messageEvent struct {
+	from hostId
+	msg  *message
+}
+
+type networkErrorEvent struct {
+	peerId hostId
+	err    error
+}
+
+type dialErrorEvent struct {
+	peerId hostId
+}
+
+type shutdownPeerEvent struct {
+	peerId hostId
+}
+
+type shutdownMessengerEvent struct{}
+
+type heartbeatEvent struct{}
+
+func (dialEvent) msgrEvent()              {}
+func (connectedEvent) msgrEvent()         {}
+func (writeResultEvent) msgrEvent()       {}
+func (sendMessageEvent) msgrEvent()       {}
+func (broadcastMessageEvent) msgrEvent()  {}
+func (subscribeEvent) msgrEvent()         {}
+func (leaveEvent) msgrEvent()             {}
+func (cancelMessageEvent) msgrEvent()     {}
+func (messageEvent) msgrEvent()           {}
+func (networkErrorEvent) msgrEvent()      {}
+func (dialErrorEvent) msgrEvent()         {}
+func (shutdownPeerEvent) msgrEvent()      {}
+func (shutdownMessengerEvent) msgrEvent() {}
+func (heartbeatEvent) msgrEvent()         {}
+
+func (msgr *messenger) handleEvent(event msgrEvent) {
+	switch event := event.(type) {
+	case dialEvent:
+		msgr.handleDial(event)
+	case connectedEvent:
+		msgr.handleConnected(event)
+	case writeResultEvent:
+		msgr.handleWriteResult(event)
+	case sendMessageEvent:
+		msgr.handleSendMessage(event)
+	case broadcastMessageEvent:
+		msgr.handleBroadcastMessage(event)
+	case subscribeEvent:
+		msgr.handleSubscribe(event)
+	case leaveEvent:
+		msgr.handleLeave(event)
+	case cancelMessageEvent:
+		msgr.handleCancelMessage(event)
+	case messageEvent:
+		msgr.handleMessage(event)
+	case networkErrorEvent:
+		msgr.handleNetworkError(event)
+	case dialErrorEvent:
+		msgr.handleDialError(event)
+	case shutdownPeerEvent:
+		msgr.handleShutdownPeer(event)
+	case shutdownMessengerEvent:
+		msgr.handleShutdownMessenger(event)
+	case heartbeatEvent:
+		msgr.handleHeartbeat(event)
+	}
+}
@@ -0,0 +1,117 @@
+package messenger
+
+import "errors"
+
+// RejectedError is returned to a requester when the subscriber's queue is full.
+var RejectedError = errors.New("rejected: handler queue is full")
+
+// ExecutionMode controls how the handler of a subscription runs incoming messages.
+type ExecutionMode struct {
+	// Concurrency is the number of handler invocations running at once.
+	// Zero means unbounded: every message gets its own goroutine.
+	Concurrency int
+
+	// QueueLimit is the number of messages that may wait for a free slot
+	// when Concurrency handlers are busy. Further requests are rejected
+	// with RejectedError; further published messages are dropped. So are
+	// the queued messages when the subscription is replaced or removed,
+	// or the messenger leaves. A negative limit is taken as zero.
+	QueueLimit int
+}
+
+// Unbounded runs every message in its own goroutine. This is the default.
+func Unbounded() ExecutionMode {
+	return ExecutionMode{}
+}
+
+// Bounded runs at most concurrency handlers at once, queueing up to queueLimit messages.
+// A negative queueLimit queues nothing.
+func Bounded(concurrency, queueLimit int) ExecutionMode {
+	return ExecutionMode{Concurrency: concurrency, QueueLimit: max(queueLimit, 0)}
+}
+
+// Serial runs one handler at a time, in arrival order, queueing up to queueLimit messages.
+func Serial(queueLimit int) ExecutionMode {
+	return Bounded(1, queueLimit)
+}
+
+type subscription struct {
+	handler MessageHandler
+	*executor
+}
+
+// executor runs jobs according to an ExecutionMode.
+// A nil executor runs every job in a new goroutine.
+type executor struct {
+	jobs chan job
+	done chan struct{}
+}
+
+// job is a message for a handler. reject answers it instead if the
+// subscription stops while the job is still queued.
+type job struct {
+	run    func()
+	reject func()
+}
+
+func newExecutor(mode ExecutionMode) *executor {
+	if mode.Concurrency <= 0 {
+		return nil
+	}
+	exec := &executor{
+		jobs: make(chan job, max(mode.QueueLimit, 0)),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < mode.Concurrency; i++ {
+		go exec.work()
+	}
+	return exec
+}
+
+func (exec *executor) work() {
+	for {
+		select {
+		case job := <-exec.jobs:
+			job.run()
+		case <-exec.done:
+			return
+		}
+	}
+}
+
+// submit schedules run and reports false if the queue is full or the executor
+// is stopped. reject is called instead of run if the executor stops first.
+func (exec *executor) submit(run, reject func()) bool {
+	if exec == nil {
+		go run()
+		return true
+	}
+	select {
+	case <-exec.done:
+		return false
+	default:
+	}
+	select {
+	case exec.jobs <- job{run: run, reject: reject}:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop ends the workers once their current jobs are done and rejects
+// the queued ones, so that no request waits for a reply that never comes.
+func (exec *executor) stop() {
+	if exec == nil {
+		return
+	}
+	close(exec.done)
+	for {
+		select {
+		case job := <-exec.jobs:
+			job.reject()
+		default:
+			return
+		}
+	}
+}
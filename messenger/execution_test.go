@@ -0,0 +1,157 @@
+package messenger
+
+import (
+	"context"
+	"github.com/andrew-suprun/envoy/future"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSerialExecution(t *testing.T) {
+	log.Println("---------------- TestSerialExecution ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	var running, maxRunning int32
+	server.SubscribeMode("job", "", Serial(10), func(msg *Message) ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		if n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return msg.Body, nil
+	})
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := client.Request("job", []byte("Hello")); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning != 1 {
+		t.Fatalf("Expected one handler at a time; received %d", maxRunning)
+	}
+}
+
+func TestRejectedExecution(t *testing.T) {
+	log.Println("---------------- TestRejectedExecution ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	release := make(chan struct{})
+	server.SubscribeMode("job", "", Bounded(1, 1), func(msg *Message) ([]byte, error) {
+		<-release
+		return msg.Body, nil
+	})
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	wg := sync.WaitGroup{}
+	var rejected int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := client.Request("job", []byte("Hello"))
+			if err == RejectedError {
+				atomic.AddInt32(&rejected, 1)
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if rejected != 2 {
+		t.Fatalf("Expected 2 rejected requests; received %d", rejected)
+	}
+}
+
+func TestUnsubscribeRejectsQueued(t *testing.T) {
+	log.Println("---------------- TestUnsubscribeRejectsQueued ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	release := make(chan struct{})
+	server.SubscribeMode("job", "", Bounded(1, 2), func(msg *Message) ([]byte, error) {
+		<-release
+		return msg.Body, nil
+	})
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	running, _ := client.RequestAsync(context.Background(), "job", []byte("Hello"))
+	time.Sleep(50 * time.Millisecond)
+	queued1, _ := client.RequestAsync(context.Background(), "job", []byte("Hello"))
+	queued2, _ := client.RequestAsync(context.Background(), "job", []byte("Hello"))
+	time.Sleep(50 * time.Millisecond)
+
+	server.Unsubscribe("job")
+	for _, queued := range []future.Typed[[]byte]{queued1, queued2} {
+		if _, err := queued.WaitTimeout(time.Second); err != RejectedError {
+			t.Errorf("Expected RejectedError for a queued request; received %v", err)
+		}
+	}
+	close(release)
+	if body, err := running.WaitTimeout(time.Second); err != nil || string(body) != "Hello" {
+		t.Errorf("Expected the running request to complete; received '%s'; err = %v", body, err)
+	}
+}
+
+func TestNegativeQueueLimit(t *testing.T) {
+	if mode := Serial(-1); mode.QueueLimit != 0 {
+		t.Errorf("Expected a negative queue limit to be taken as zero; received %d", mode.QueueLimit)
+	}
+	exec := newExecutor(ExecutionMode{Concurrency: 1, QueueLimit: -1})
+	defer exec.stop()
+	// Without a queue a job is taken only by a worker that is waiting for one.
+	ran := make(chan struct{})
+	for deadline := time.Now().Add(time.Second); !exec.submit(func() { close(ran) }, func() {}); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected an idle worker to take the job")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	<-ran
+}
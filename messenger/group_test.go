@@ -0,0 +1,110 @@
+package messenger
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"testing"
+)
+
+func TestQueueGroups(t *testing.T) {
+	log.Println("---------------- TestQueueGroups ----------------")
+
+	const count = 40
+	var mutex sync.Mutex
+	received := map[string]int{}
+	wg := sync.WaitGroup{}
+	wg.Add(2 * count)
+	counter := func(name string) Handler {
+		return func(topic string, body []byte) []byte {
+			mutex.Lock()
+			received[name]++
+			mutex.Unlock()
+			wg.Done()
+			return []byte(name)
+		}
+	}
+
+	server1, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server1.Leave()
+	server1.SubscribeGroup("job", "billing", counter("billing-1"))
+	server1.SubscribeGroup("job", "audit", counter("audit-1"))
+	server1.Join()
+
+	server2, err := NewMessenger("localhost:50001")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server2.Leave()
+	server2.SubscribeGroup("job", "billing", counter("billing-2"))
+	server2.Join("localhost:50000")
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000", "localhost:50001")
+
+	for i := 0; i < count; i++ {
+		if _, err := client.Publish("job", []byte("Hello")); err != nil {
+			t.Fatalf("Publish returned error: %s", err)
+		}
+	}
+	wg.Wait()
+
+	mutex.Lock()
+	log.Printf("counts: %v", received)
+	if received["audit-1"] != count || received["billing-1"]+received["billing-2"] != count ||
+		received["billing-1"] == 0 || received["billing-2"] == 0 {
+		t.Errorf("Wrong counts: %v", received)
+	}
+	mutex.Unlock()
+
+	wg.Add(1)
+	reply, _, err := client.Request("job", []byte("Hello"))
+	if err != nil || string(reply) != "audit-1" {
+		t.Fatalf("Expected: 'audit-1'; received '%s'; err = %v", string(reply), err)
+	}
+}
+
+func TestSubscribeMessageFormat(t *testing.T) {
+	for _, test := range []struct {
+		g      group
+		groups []group
+	}{{defaultGroup, nil}, {"billing", []group{"billing"}}} {
+		msg := newSubscribeMessage(subscribe, "job", test.g)
+		// Peers that predate queue groups decode the body as a topic.
+		var decoded topic
+		if err := decode(&ch, bytes.NewBuffer(msg.Body), &decoded); err != nil || decoded != "job" {
+			t.Errorf("Group '%s': expected topic 'job' in the body; received '%s'; err = %v", test.g, decoded, err)
+		}
+		if len(msg.Groups) != len(test.groups) || subscribedGroup(msg) != test.g {
+			t.Errorf("Group '%s': unexpected groups %v", test.g, msg.Groups)
+		}
+	}
+}
+
+func TestAllReplies(t *testing.T) {
+	var results []error
+	done := allReplies(2, func(_ *message, err error) { results = append(results, err) })
+	done(nil, nil)
+	if len(results) != 0 {
+		t.Fatalf("Completed after the first of two servers")
+	}
+	done(nil, nil)
+	if len(results) != 1 || results[0] != nil {
+		t.Fatalf("Expected one successful result; received %v", results)
+	}
+
+	results = nil
+	done = allReplies(2, func(_ *message, err error) { results = append(results, err) })
+	done(nil, ServerDisconnectedError)
+	done(nil, nil)
+	if len(results) != 1 || results[0] != ServerDisconnectedError {
+		t.Fatalf("Expected the first error alone; received %v", results)
+	}
+}
@@ -0,0 +1,75 @@
+package messenger
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+const hashRingReplicas = 128
+
+// hashRing is a consistent-hash ring of peers. Each peer owns hashRingReplicas
+// points on the ring, so adding or removing a peer only remaps the keys that
+// land on its points.
+type hashRing struct {
+	points  []uint64
+	owners  map[uint64]hostId
+	members map[hostId]struct{}
+}
+
+func newHashRing() *hashRing {
+	return &hashRing{owners: make(map[uint64]hostId), members: make(map[hostId]struct{})}
+}
+
+func (ring *hashRing) add(id hostId) {
+	if _, found := ring.members[id]; found {
+		return
+	}
+	ring.members[id] = struct{}{}
+	for i := 0; i < hashRingReplicas; i++ {
+		point := hashKey(string(id) + "#" + strconv.Itoa(i))
+		if _, taken := ring.owners[point]; taken {
+			continue
+		}
+		ring.owners[point] = id
+		ring.points = append(ring.points, point)
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+}
+
+func (ring *hashRing) remove(id hostId) {
+	if _, found := ring.members[id]; !found {
+		return
+	}
+	delete(ring.members, id)
+	points := ring.points[:0]
+	for _, point := range ring.points {
+		if ring.owners[point] == id {
+			delete(ring.owners, point)
+		} else {
+			points = append(points, point)
+		}
+	}
+	ring.points = points
+}
+
+// lookup walks the ring clockwise from key and returns the first owner accepted by accept.
+func (ring *hashRing) lookup(key string, accept func(hostId) bool) (hostId, bool) {
+	if len(ring.points) == 0 {
+		return "", false
+	}
+	start := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= hashKey(key) })
+	for i := 0; i < len(ring.points); i++ {
+		owner := ring.owners[ring.points[(start+i)%len(ring.points)]]
+		if accept(owner) {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
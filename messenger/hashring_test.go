@@ -0,0 +1,87 @@
+package messenger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"testing"
+)
+
+func TestHashRingRemapping(t *testing.T) {
+	ring := newHashRing()
+	for i := 0; i < 5; i++ {
+		ring.add(hostId(fmt.Sprintf("127.0.0.1:5000%d", i)))
+	}
+	any := func(hostId) bool { return true }
+
+	owners := map[string]hostId{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("customer-%d", i)
+		owners[key], _ = ring.lookup(key, any)
+	}
+
+	removed := hostId("127.0.0.1:50002")
+	ring.remove(removed)
+	for key, owner := range owners {
+		newOwner, _ := ring.lookup(key, any)
+		if owner != removed && newOwner != owner {
+			t.Fatalf("Key %s moved from %s to %s", key, owner, newOwner)
+		}
+		if newOwner == removed {
+			t.Fatalf("Key %s is still owned by removed %s", key, removed)
+		}
+	}
+
+	ring.add(removed)
+	for key, owner := range owners {
+		if newOwner, _ := ring.lookup(key, any); newOwner != owner {
+			t.Fatalf("Key %s moved from %s to %s after re-adding", key, owner, newOwner)
+		}
+	}
+}
+
+func TestRequestWithKey(t *testing.T) {
+	log.Println("---------------- TestRequestWithKey ----------------")
+
+	server1, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server1.Leave()
+	server1.Subscribe("job", echo1)
+	server1.Join()
+
+	server2, err := NewMessenger("localhost:50001")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server2.Leave()
+	server2.Subscribe("job", echo2)
+	server2.Join("localhost:50000")
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000", "localhost:50001")
+
+	servers := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("customer-%d", i)
+		first, _, err := client.RequestWithKey(context.Background(), "job", key, []byte("Hello"))
+		if err != nil {
+			t.Fatalf("Request returned error: %s", err)
+		}
+		for j := 0; j < 5; j++ {
+			reply, _, err := client.RequestWithKey(context.Background(), "job", key, []byte("Hello"))
+			if err != nil || string(reply) != string(first) {
+				t.Fatalf("Key %s: expected '%s'; received '%s'; err = %v", key, string(first), string(reply), err)
+			}
+		}
+		servers[string(first)] = true
+	}
+	if len(servers) != 2 {
+		t.Errorf("Keys were not spread over both servers: %v", servers)
+	}
+}
@@ -0,0 +1,45 @@
+package messenger
+
+import "context"
+
+// Headers carry message metadata, such as trace ids, content type,
+// auth tokens or tenant ids, alongside the body.
+type Headers map[string]string
+
+// Message is an incoming message as seen by a MessageHandler,
+// or the reply returned by RequestMessage.
+type Message struct {
+	Topic   string
+	Headers Headers
+	Body    []byte
+
+	// Trace is the span around the handler. Pass it to WithTrace to
+	// continue the trace in requests made while handling the message.
+	Trace SpanContext
+
+	// ReplyHeaders are sent back to the requester along with the handler's result.
+	ReplyHeaders Headers
+}
+
+// MessageHandler is a Handler with access to the message headers.
+// A returned error is sent back to the requester as a *RemoteError.
+type MessageHandler func(msg *Message) ([]byte, error)
+
+type headersKey struct{}
+
+// WithHeaders returns a context whose messages sent by the messenger's
+// Context, WithKey and Message variants carry headers.
+func WithHeaders(ctx context.Context, headers Headers) context.Context {
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+func headersFromContext(ctx context.Context) Headers {
+	headers, _ := ctx.Value(headersKey{}).(Headers)
+	return headers
+}
+
+func (handler Handler) messageHandler() MessageHandler {
+	return func(msg *Message) ([]byte, error) {
+		return handler(msg.Topic, msg.Body), nil
+	}
+}
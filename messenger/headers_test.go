@@ -0,0 +1,42 @@
+package messenger
+
+import (
+	"context"
+	"log"
+	"testing"
+)
+
+func TestHeaders(t *testing.T) {
+	log.Println("---------------- TestHeaders ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.SubscribeMessages("job", "", func(msg *Message) ([]byte, error) {
+		msg.ReplyHeaders["tenant"] = msg.Headers["tenant"]
+		msg.ReplyHeaders["content-type"] = "text/plain"
+		return msg.Body, nil
+	})
+	server.Join()
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	ctx := WithHeaders(context.Background(), Headers{"tenant": "acme"})
+	reply, _, err := client.RequestMessage(ctx, "job", []byte("Hello"))
+	if err != nil {
+		t.Fatalf("Request returned error: %s", err)
+	}
+	if string(reply.Body) != "Hello" {
+		t.Fatalf("Expected: 'Hello'; received '%s'", string(reply.Body))
+	}
+	if reply.Headers["tenant"] != "acme" || reply.Headers["content-type"] != "text/plain" {
+		t.Fatalf("Wrong reply headers: %v", reply.Headers)
+	}
+}
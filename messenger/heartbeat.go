@@ -0,0 +1,42 @@
+package messenger
+
+import (
+	"time"
+)
+
+// scheduleHeartbeat makes the messenger ping its peers after HeartbeatInterval.
+func (msgr *messenger) scheduleHeartbeat() {
+	if msgr.HeartbeatInterval > 0 {
+		time.AfterFunc(msgr.HeartbeatInterval, func() {
+			msgr.Send(heartbeatEvent{})
+		})
+	}
+}
+
+// handleHeartbeat pings every connected peer that answers pings. The pings and
+// pongs keep idle connections readable, so that a connection that stays silent
+// for IdleTimeout belongs to a dead peer and fails with a network error.
+func (msgr *messenger) handleHeartbeat(heartbeatEvent) {
+	if msgr.state == messengerLeaving {
+		return
+	}
+	for _, peer := range msgr.peers {
+		if peer.state == peerConnected && peer.supports(capHeartbeat) {
+			peer.write(&message{MessageId: newId(), MessageType: ping})
+		}
+	}
+	msgr.scheduleHeartbeat()
+}
+
+func (msgr *messenger) handlePing(peer *peer, msg *message) {
+	peer.write(&message{MessageId: msg.MessageId, MessageType: pong})
+}
+
+// idleTimeout returns the read deadline for the peer's connection; none for
+// peers that do not answer pings, as their connections may be idle for long.
+func (peer *peer) idleTimeout() time.Duration {
+	if !peer.supports(capHeartbeat) || peer.msgr.HeartbeatInterval <= 0 {
+		return 0
+	}
+	return peer.msgr.IdleTimeout
+}
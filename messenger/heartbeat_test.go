@@ -0,0 +1,90 @@
+package messenger
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPingPong(t *testing.T) {
+	log.Println("---------------- TestPingPong ----------------")
+
+	server, err := NewMessengerWithOptions("localhost:50000", Options{HeartbeatInterval: time.Hour, IdleTimeout: 300 * time.Millisecond})
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Join()
+
+	conn, _ := rawJoin(t, "localhost:50000", &joinMessage{HostId: "127.0.0.1:1", Version: protocolVersion, Capabilities: []string{capHeartbeat}})
+	defer conn.Close()
+	msg := &message{MessageId: newId(), MessageType: ping}
+	writeMessage(conn, msg, defaultCodec)
+	pongMsg, err := readMessage(conn, defaultCodec, defaultMaxFrameSize)
+	if err != nil || pongMsg.MessageType != pong || pongMsg.MessageId != msg.MessageId {
+		t.Fatalf("Expected pong; received %+v; err = %v", pongMsg, err)
+	}
+
+	// A peer that goes silent is disconnected once IdleTimeout passes.
+	start := time.Now()
+	if _, err := readMessage(conn, defaultCodec, defaultMaxFrameSize); err == nil {
+		t.Fatalf("Silent peer was not disconnected")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Silent peer disconnected after %s", elapsed)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	log.Println("---------------- TestHeartbeat ----------------")
+
+	opts := Options{HeartbeatInterval: 50 * time.Millisecond, IdleTimeout: 200 * time.Millisecond}
+	serverMetrics := NewRegistry()
+	serverOpts := opts
+	serverOpts.Metrics = serverMetrics
+	server, err := NewMessengerWithOptions("localhost:50000", serverOpts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	client, err := NewMessengerWithOptions("localhost:40000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	time.Sleep(600 * time.Millisecond)
+	if reply, _, err := client.Request("job", []byte("Hello")); err != nil || string(reply) != "Hello" {
+		t.Fatalf("Expected 'Hello' after idling; received '%s'; err = %v", reply, err)
+	}
+	text := &strings.Builder{}
+	serverMetrics.WriteText(text)
+	if strings.Contains(text.String(), "envoy_redials_total") {
+		t.Errorf("Idle connection with heartbeats was dropped:\n%s", text)
+	}
+}
+
+func TestLegacyPeerNotTimedOut(t *testing.T) {
+	log.Println("---------------- TestLegacyPeerNotTimedOut ----------------")
+
+	server, err := NewMessengerWithOptions("localhost:50000", Options{HeartbeatInterval: 50 * time.Millisecond, IdleTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Join()
+
+	conn, _ := rawJoin(t, "localhost:50000", &joinMessage{HostId: "127.0.0.1:1"})
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(400 * time.Millisecond))
+	if msg, err := readMessage(conn, defaultCodec, defaultMaxFrameSize); err == nil {
+		t.Errorf("Peer without heartbeats received %+v", msg)
+	} else if !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("Peer without heartbeats was disconnected: %v", err)
+	}
+}
@@ -2,88 +2,146 @@ package messenger
 
 import (
 	"bytes"
+	"crypto/tls"
+	"fmt"
 	"github.com/andrew-suprun/envoy/actor"
 	"log"
 	"net"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
 )
 
+// handshakeTimeout bounds reading the join invite of an accepted connection,
+// including its TLS handshake, unless Options.Timeout is shorter.
+const handshakeTimeout = 5 * time.Second
+
 type listener struct {
 	name string
-	actor.Actor
+	actor.TypedActor[listenerRequest]
 	net.Listener
 	joinMsg *joinMessage
-	msgr    actor.Actor
-	stopped bool
+	msgr    actor.TypedActor[msgrEvent]
+	stopped atomic.Bool // set by Stop while handleAccept runs
+	*Options
+}
+
+// listenerRequest is a message handled by the listener actor.
+type listenerRequest interface {
+	listenerRequest()
 }
 
-func newListener(name string, msgr actor.Actor, joinMsg *joinMessage) (actor.Actor, error) {
+type acceptRequest struct{}
+
+type setJoinMessageRequest struct {
+	joinMsg *joinMessage
+}
+
+func (acceptRequest) listenerRequest()         {}
+func (setJoinMessageRequest) listenerRequest() {}
+
+func newListener(name string, msgr actor.TypedActor[msgrEvent], joinMsg *joinMessage, supervisor actor.Supervisor, opts *Options) (*listener, error) {
 	lsnr := &listener{
 		name:    name,
-		Actor:   actor.NewActor(name),
 		joinMsg: joinMsg,
 		msgr:    msgr,
+		Options: opts,
 	}
-	lsnr.
-		RegisterHandler("set-join-message", lsnr.handleSetJoinMessage).
-		RegisterHandler("accept", lsnr.handleAccept).
-		RegisterHandler("stop", lsnr.handleStop).
-		Start()
+	lsnr.TypedActor = actor.NewTypedActor(name, lsnr.handleRequest, actor.Supervise(supervisor)).Start()
 
 	var err error
-	lsnr.Listener, err = net.Listen("tcp", string(lsnr.joinMsg.HostId))
+	lsnr.Listener, err = net.Listen("tcp", lsnr.ListenAddress)
 	if err != nil {
-		Log.Errorf("Failed to listen on %s. Exiting.", lsnr.joinMsg.HostId)
+		lsnr.Log.Errorf("Failed to listen on %s. Exiting.", lsnr.ListenAddress)
+		lsnr.TypedActor.Stop()
 		return nil, err
 	}
-	Log.Infof("Listening on: %s", lsnr.joinMsg.HostId)
-	lsnr.Send("accept")
+	if lsnr.TLSConfig != nil {
+		lsnr.Listener = tls.NewListener(lsnr.Listener, lsnr.TLSConfig)
+	}
+	lsnr.Log.Infof("Listening on: %s", lsnr.ListenAddress)
+	lsnr.Send(acceptRequest{})
 	return lsnr, nil
 }
 
-func (lsnr *listener) handleSetJoinMessage(_ string, info []interface{}) {
-	lsnr.joinMsg = info[0].(*joinMessage)
+func (lsnr *listener) handleRequest(req listenerRequest) {
+	switch req := req.(type) {
+	case acceptRequest:
+		lsnr.handleAccept()
+	case setJoinMessageRequest:
+		lsnr.joinMsg = req.joinMsg
+	}
 }
 
-func (lsnr *listener) handleStop(_ string, _ []interface{}) {
-	lsnr.stopped = true
+func (lsnr *listener) Stop() {
+	lsnr.stopped.Store(true)
 	lsnr.Listener.Close()
+	lsnr.TypedActor.Stop()
 }
 
-func (lsnr *listener) handleAccept(_ string, _ []interface{}) {
+func (lsnr *listener) handleAccept() {
 	defer func() {
-		if !lsnr.stopped {
-			lsnr.Send("accept")
+		if !lsnr.stopped.Load() {
+			lsnr.Send(acceptRequest{})
 		}
 	}()
 
 	conn, err := lsnr.Listener.Accept()
 	if err != nil {
-		if !lsnr.stopped {
-			Log.Errorf("Failed to accept connection: err = %v", err)
+		if !lsnr.stopped.Load() {
+			lsnr.Log.Errorf("Failed to accept connection: err = %v", err)
 		}
 		return
 	}
+	// Each handshake runs on its own, so a slow or silent client holds up no other join.
+	go lsnr.handshake(conn)
+}
+
+// handshake reads the join invite of an accepted connection and hands the
+// connection over to the messenger. Until then the connection is closed on
+// any failure, panics included.
+func (lsnr *listener) handshake(conn net.Conn) {
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			conn.Close()
+		}
+		if reason := recover(); reason != nil {
+			lsnr.Log.Panic(reason, string(debug.Stack()))
+		}
+	}()
 
 	joinMsg, err := lsnr.readJoinInvite(conn)
 	if err != nil {
-		if !lsnr.stopped {
-			Log.Errorf("Failed to read join invite: err = %v", err)
+		if !lsnr.stopped.Load() {
+			lsnr.Log.Errorf("Failed to read join invite: err = %v", err)
 		}
 		return
 	}
+	if lsnr.stopped.Load() {
+		return
+	}
 
-	lsnr.msgr.Send("accepted", conn, joinMsg)
+	lsnr.msgr.Send(connectedEvent{conn: conn, joinMsg: joinMsg, accepted: true})
+	handedOver = true
 }
 
 func (lsnr *listener) readJoinInvite(conn net.Conn) (*joinMessage, error) {
-	msg, err := readMessage(conn)
+	conn.SetReadDeadline(time.Now().Add(min(handshakeTimeout, lsnr.Timeout)))
+	msg, err := readMessage(conn, defaultCodec, lsnr.MaxFrameSize)
 	if err != nil {
 		return nil, err
 	}
+	conn.SetReadDeadline(time.Time{})
+	if msg.MessageType != join {
+		return nil, fmt.Errorf("%w: expected join from %s, received %s", MalformedFrameError, conn.RemoteAddr(), msg.MessageType)
+	}
 
 	buf := bytes.NewBuffer(msg.Body)
 	var reply joinMessage
-	decode(buf, &reply)
+	if err := decode(&ch, buf, &reply); err != nil {
+		return nil, err
+	}
 	return &reply, nil
 }
 
@@ -2,27 +2,32 @@ package messenger
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/andrew-suprun/envoy/actor"
 	"github.com/andrew-suprun/envoy/future"
-	mRand "math/rand"
 	"net"
+	"os"
 	"runtime/debug"
+	"sort"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	ServerDisconnectedError = errors.New("server disconnected")
-	TimeoutError            = errors.New("timed out")
-	NoSubscribersError      = errors.New("no subscribers found")
-	NoHandlerError          = errors.New("no handler for topic found")
-	NilConnError            = errors.New("null connection")
-	PanicError              = errors.New("server panic-ed")
+	ServerDisconnectedError       = errors.New("server disconnected")
+	TimeoutError            error = timeoutError{}
+	NoSubscribersError            = errors.New("no subscribers found")
+	NoHandlerError                = errors.New("no handler for topic found")
+	NilConnError                  = errors.New("null connection")
+	PanicError                    = errors.New("server panic-ed")
 )
 
+// Defaults for messengers created without explicit Options.
 var (
 	Timeout        time.Duration = 30 * time.Second
 	RedialInterval time.Duration = 10 * time.Second
@@ -35,13 +40,56 @@ type Messenger interface {
 
 	Publish(topic string, body []byte) (MessageId, error)
 	Request(topic string, body []byte) ([]byte, MessageId, error)
+	// Broadcast and Survey reach every peer subscribed to the topic, and the
+	// local messenger if it is subscribed. Failures of individual peers are
+	// reported as *BroadcastError next to the replies of the others.
 	Broadcast(topic string, body []byte) (MessageId, error)
 	Survey(topic string, body []byte) ([][]byte, MessageId, error)
 
-	// No more than one subscription per topic.
+	// Context variants are bounded by both the context and Options.Timeout.
+	// A cancelled or expired call returns ctx.Err() (or TimeoutError,
+	// which matches context.DeadlineExceeded) and drops its pending replies.
+	PublishContext(ctx context.Context, topic string, body []byte) (MessageId, error)
+	RequestContext(ctx context.Context, topic string, body []byte) ([]byte, MessageId, error)
+	BroadcastContext(ctx context.Context, topic string, body []byte) (MessageId, error)
+	SurveyContext(ctx context.Context, topic string, body []byte) ([][]byte, MessageId, error)
+
+	// Keyed variants route messages with the same key to the same subscriber
+	// (one per queue group) using a consistent-hash ring of the peers, so only
+	// a small share of keys moves when peers join or leave.
+	PublishWithKey(ctx context.Context, topic, key string, body []byte) (MessageId, error)
+	RequestWithKey(ctx context.Context, topic, key string, body []byte) ([]byte, MessageId, error)
+
+	// Async variants return immediately. The future is set to the reply body ([]byte)
+	// for RequestAsync, or to the reply bodies ([][]byte) for SurveyAsync, or to an error.
+	RequestAsync(ctx context.Context, topic string, body []byte) (future.Typed[[]byte], MessageId)
+	SurveyAsync(ctx context.Context, topic string, body []byte) (future.Typed[[][]byte], MessageId)
+
+	// RequestMessage is RequestContext returning the whole reply, including its headers.
+	// Outgoing headers are attached to ctx with WithHeaders.
+	RequestMessage(ctx context.Context, topic string, body []byte) (*Message, MessageId, error)
+
+	// No more than one subscription per topic and queue group.
 	// Second subscription panics.
+	// Topics may contain "*" and ">" wildcards (see topic.go); a message is
+	// handled by the most specific matching subscription.
 	Subscribe(topic string, handler Handler)
 	Unsubscribe(topic string)
+
+	// SubscribeGroup subscribes handler as a member of a named queue group.
+	// Every group subscribed to a topic receives each published message,
+	// delivered to a single member of the group. A request is served by
+	// the default group (the one Subscribe uses) when it has members,
+	// otherwise by the first group in name order.
+	SubscribeGroup(topic, group string, handler Handler)
+	UnsubscribeGroup(topic, group string)
+
+	// SubscribeMessages is SubscribeGroup for handlers that need message headers
+	// or report failures as errors; requesters receive those as *RemoteError.
+	SubscribeMessages(topic, group string, handler MessageHandler)
+
+	// SubscribeMode is SubscribeMessages with an ExecutionMode other than Unbounded.
+	SubscribeMode(topic, group string, mode ExecutionMode, handler MessageHandler)
 }
 
 type MessageId interface {
@@ -50,6 +98,12 @@ type MessageId interface {
 
 type Handler func(topic string, body []byte) []byte
 
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timed out" }
+
+func (timeoutError) Is(target error) bool { return target == context.DeadlineExceeded }
+
 const (
 	publish messageType = iota
 	request
@@ -60,28 +114,37 @@ const (
 	left
 	subscribe
 	unsubscribe
+	replyError
+	replyRejected
+	ping
+	pong
 )
 
 const (
 	messageIdSize = 16
+	defaultGroup  = group("")
 )
 
 type (
 	topic       string
+	group       string
 	hostId      string
 	messageId   [messageIdSize]byte
 	messageType int
 )
 
 type messenger struct {
-	actor.Actor
+	actor.TypedActor[msgrEvent]
 	hostId
-	subscriptions map[topic]Handler
-	peers         map[hostId]*peer
-	listener      actor.Actor
-	dialer        actor.Actor
-	state         messengerState
-	leaveFuture   future.Future
+	Options
+	subscriptions  map[group]map[topic]*subscription
+	topicBalancers map[topic]Balancer
+	ring           *hashRing
+	peers          map[hostId]*peer
+	listener       *listener
+	dialer         actor.TypedActor[dialRequest]
+	state          messengerState
+	leaveFuture    future.Typed[bool]
 }
 
 type messengerState int
@@ -92,15 +155,30 @@ const (
 )
 
 type peer struct {
-	msgr           actor.Actor
+	msgr           *messenger
 	msgrId         hostId
 	peerId         hostId
 	conn           net.Conn
-	topics         map[topic]struct{}
-	pendingReplies map[messageId]future.Future
-	reader         actor.Actor
-	writer         actor.Actor
+	certificates   []*x509.Certificate
+	capabilities   map[string]bool // shared with this peer
+	codec          Codec
+	compressor     Compressor // nil when bodies go uncompressed
+	maxMessageSize int        // largest body the peer accepts; unknown if zero
+	failure        error      // the network error that is stopping the peer
+	topics         map[group]map[topic]struct{}
+	pendingReplies map[messageId]*pendingReply
+	joinResults    []future.Typed[bool]
+	reader         actor.TypedActor[struct{}]
+	writer         actor.TypedActor[writeRequest]
 	state          peerState
+	draining       bool // leaving or left; shut down once pendingReplies is empty
+}
+
+// pendingReply is a reply, or a write result, that a peer owes a sender.
+// done is called on the messenger with the reply or with the error that ended the wait.
+type pendingReply struct {
+	sent time.Time
+	done func(reply *message, err error)
 }
 
 type peerState int
@@ -117,6 +195,11 @@ type message struct {
 	MessageType messageType `codec:"mt"`
 	Topic       topic       `codec:"t,omitempty"`
 	Body        []byte      `codec:"b,omitempty"`
+	Headers     Headers     `codec:"hd,omitempty"`
+	Groups      []group     `codec:"g,omitempty"`  // queue groups to deliver to; default group if empty
+	TraceParent string      `codec:"tp,omitempty"` // W3C traceparent of the sender's span
+	More        bool        `codec:"m,omitempty"`  // more parts of the body follow
+	Compressed  bool        `codec:"z,omitempty"`  // Body is compressed with the connection's compressor
 }
 
 type clientMessage struct {
@@ -125,45 +208,56 @@ type clientMessage struct {
 }
 
 type joinMessage struct {
-	HostId hostId   `codec:"h,omitempty"`
-	Topics []topic  `codec:"t,omitempty"`
-	Peers  []hostId `codec:"p,omitempty"`
+	HostId       hostId                 `codec:"h,omitempty"`
+	Version      int                    `codec:"v,omitempty"`
+	MinVersion   int                    `codec:"mv,omitempty"`
+	Capabilities []string               `codec:"c,omitempty"`
+	Codecs       []string               `codec:"cs,omitempty"` // offered by the dialer, preferred first
+	Codec        string                 `codec:"cd,omitempty"` // chosen by the acceptor
+	Compressors  []string               `codec:"zs,omitempty"` // offered by the dialer, preferred first
+	Compressor   string                 `codec:"z,omitempty"`  // chosen by the acceptor; none if empty
+	MaxMessage   int                    `codec:"mm,omitempty"` // largest body accepted; unknown if zero
+	Topics       []topic                `codec:"t,omitempty"`  // default group
+	Groups       []subscribeMessageBody `codec:"g,omitempty"`  // named groups
+	Peers        []hostId               `codec:"p,omitempty"`
 }
 
+// subscribeMessageBody lists a named group subscription in join messages.
 type subscribeMessageBody struct {
-	HostId hostId `codec:"id,omitempty"`
-	Topic  topic  `codec:"t,omitempty"`
+	Topic topic `codec:"t,omitempty"`
+	Group group `codec:"g,omitempty"`
 }
 
 func NewMessenger(local string) (Messenger, error) {
+	return NewMessengerWithOptions(local, Options{})
+}
+
+func NewMessengerWithOptions(local string, opts Options) (Messenger, error) {
 	localAddr, err := resolveAddr(local)
 	if err != nil {
 		return nil, err
 	}
 
 	msgr := &messenger{
-		hostId:        hostId(localAddr),
-		subscriptions: make(map[topic]Handler),
-		peers:         make(map[hostId]*peer),
-	}
-
-	msgr.Actor = actor.NewActor(string(msgr.hostId)+"-messenger").
-		RegisterHandler("dial", msgr.handleDial).
-		RegisterHandler("dialed", msgr.handleConnected).
-		RegisterHandler("accepted", msgr.handleConnected).
-		RegisterHandler("write-result", msgr.handleWriteResult).
-		RegisterHandler("send-message", msgr.handleSendMessage).
-		RegisterHandler("broadcast-message", msgr.handleBroadcastMessage).
-		RegisterHandler("message", msgr.handleMessage).
-		RegisterHandler("network-error", msgr.handleNetworkError).
-		RegisterHandler("dial-error", msgr.handleDialError).
-		RegisterHandler("shutdown-peer", msgr.handleShutdownPeer).
-		RegisterHandler("shutdown-messenger", msgr.handleShutdownMessenger).
+		hostId:         hostId(localAddr),
+		Options:        opts.withDefaults(localAddr),
+		subscriptions:  make(map[group]map[topic]*subscription),
+		topicBalancers: make(map[topic]Balancer),
+		ring:           newHashRing(),
+		peers:          make(map[hostId]*peer),
+	}
+	for pattern, balancer := range msgr.TopicBalancers {
+		msgr.topicBalancers[topic(pattern)] = balancer
+	}
+
+	msgr.TypedActor = actor.NewTypedActor(string(msgr.hostId)+"-messenger", msgr.handleEvent, actor.Supervise(msgr.messengerSupervisor)).
 		Start()
 
-	msgr.dialer = newDialer(string(msgr.hostId)+"-dialer", msgr)
+	msgr.scheduleHeartbeat()
+
+	msgr.dialer = newDialer(string(msgr.hostId)+"-dialer", msgr, msgr.dialerSupervisor, &msgr.Options)
 
-	msgr.listener, err = newListener(string(msgr.hostId)+"-listener", msgr, msgr.newJoinMessage())
+	msgr.listener, err = newListener(string(msgr.hostId)+"-listener", msgr, msgr.newJoinMessage(), msgr.listenerSupervisor, &msgr.Options)
 	if err != nil {
 		msgr.Leave()
 		return nil, err
@@ -176,134 +270,354 @@ func (msgr *messenger) Join(remotes ...string) {
 	for _, remote := range remotes {
 		remoteAddr, err := resolveAddr(remote)
 		if err == nil {
-			result := future.NewFuture()
-			msgr.Send("dial", hostId(remoteAddr), result)
+			result := future.NewTyped[bool]()
+			msgr.Send(dialEvent{peerId: hostId(remoteAddr), result: result})
 			result.Value()
 		} else {
-			Log.Errorf("Cannot resolve address %s. Ignoring.", remote)
+			msgr.Log.Errorf("Cannot resolve address %s. Ignoring.", remote)
 		}
 	}
 }
 
 func (msgr *messenger) Leave() {
-	msgr.leaveFuture = future.NewFuture()
-	msgr.state = messengerLeaving
-	msgr.broadcastMessage("", nil, leaving)
-	msgr.listener.Stop()
+	result := future.NewTyped[bool]()
+	msgr.Send(leaveEvent{result: result})
+	msgr.broadcastMessage(context.Background(), newMessage(context.Background(), "", nil, leaving))
+	// The listener is nil if NewMessengerWithOptions failed to listen.
+	if msgr.listener != nil {
+		msgr.listener.Stop()
+	}
 	msgr.dialer.Stop()
-	msgr.Send("shutdown-messenger")
-	time.AfterFunc(Timeout, func() { msgr.leaveFuture.SetValue(false) })
-	msgr.leaveFuture.Value()
+	msgr.Send(shutdownMessengerEvent{})
+	result.WaitTimeout(msgr.Timeout)
 	msgr.Stop()
 }
 
+func (msgr *messenger) handleLeave(event leaveEvent) {
+	msgr.leaveFuture = event.result
+	msgr.state = messengerLeaving
+	for _, subs := range msgr.subscriptions {
+		for _, sub := range subs {
+			sub.stop()
+		}
+	}
+}
+
 func (msgr *messenger) Publish(t string, body []byte) (MessageId, error) {
-	_, msgId, err := msgr.sendMessage(topic(t), body, publish)
-	return msgId, err
+	return msgr.PublishContext(context.Background(), t, body)
 }
 
 func (msgr *messenger) Request(t string, body []byte) ([]byte, MessageId, error) {
-	return msgr.sendMessage(topic(t), body, request)
+	return msgr.RequestContext(context.Background(), t, body)
 }
 
 func (msgr *messenger) Broadcast(t string, body []byte) (MessageId, error) {
-	_, msgId, err := msgr.broadcastMessage(topic(t), body, publish)
-	return msgId, err
+	return msgr.BroadcastContext(context.Background(), t, body)
 }
 
 func (msgr *messenger) Survey(t string, body []byte) ([][]byte, MessageId, error) {
-	return msgr.broadcastMessage(topic(t), body, request)
+	return msgr.SurveyContext(context.Background(), t, body)
+}
+
+func (msgr *messenger) PublishContext(ctx context.Context, t string, body []byte) (MessageId, error) {
+	msg := newMessage(ctx, topic(t), body, publish)
+	_, err := msgr.sendMessage(ctx, msg, "")
+	return msg.MessageId, err
+}
+
+func (msgr *messenger) RequestContext(ctx context.Context, t string, body []byte) ([]byte, MessageId, error) {
+	msg := newMessage(ctx, topic(t), body, request)
+	reply, err := msgr.sendMessage(ctx, msg, "")
+	return reply.body(), msg.MessageId, err
+}
+
+func (msgr *messenger) PublishWithKey(ctx context.Context, t, key string, body []byte) (MessageId, error) {
+	msg := newMessage(ctx, topic(t), body, publish)
+	_, err := msgr.sendMessage(ctx, msg, key)
+	return msg.MessageId, err
+}
+
+func (msgr *messenger) RequestWithKey(ctx context.Context, t, key string, body []byte) ([]byte, MessageId, error) {
+	msg := newMessage(ctx, topic(t), body, request)
+	reply, err := msgr.sendMessage(ctx, msg, key)
+	return reply.body(), msg.MessageId, err
+}
+
+func (msgr *messenger) RequestMessage(ctx context.Context, t string, body []byte) (*Message, MessageId, error) {
+	msg := newMessage(ctx, topic(t), body, request)
+	reply, err := msgr.sendMessage(ctx, msg, "")
+	if err != nil {
+		return nil, msg.MessageId, err
+	}
+	return &Message{Topic: t, Headers: reply.Headers, Body: reply.Body}, msg.MessageId, nil
+}
+
+func (msgr *messenger) RequestAsync(ctx context.Context, t string, body []byte) (future.Typed[[]byte], MessageId) {
+	msg := newMessage(ctx, topic(t), body, request)
+	result := future.NewTyped[[]byte]()
+	msgr.sendAsync(ctx, msg, "", func(reply *message, err error) {
+		if err != nil {
+			result.SetError(err)
+		} else {
+			result.SetValue(reply.Body)
+		}
+	})
+	return result, msg.MessageId
+}
+
+func (msgr *messenger) BroadcastContext(ctx context.Context, t string, body []byte) (MessageId, error) {
+	msg := newMessage(ctx, topic(t), body, publish)
+	_, err := msgr.broadcastMessage(ctx, msg)
+	return msg.MessageId, err
+}
+
+func (msgr *messenger) SurveyContext(ctx context.Context, t string, body []byte) ([][]byte, MessageId, error) {
+	msg := newMessage(ctx, topic(t), body, request)
+	bodies, err := msgr.broadcastMessage(ctx, msg)
+	return bodies, msg.MessageId, err
+}
+
+func (msgr *messenger) SurveyAsync(ctx context.Context, t string, body []byte) (future.Typed[[][]byte], MessageId) {
+	msg := newMessage(ctx, topic(t), body, request)
+	result := future.NewTyped[[][]byte]()
+	msgr.broadcastAsync(ctx, msg, func(bodies [][]byte, err error) {
+		if err != nil {
+			result.SetError(err)
+		} else {
+			result.SetValue(bodies)
+		}
+	})
+	return result, msg.MessageId
 }
 
 func (msgr *messenger) Subscribe(_topic string, handler Handler) {
-	msgr.subscriptions[topic(_topic)] = handler
-	buf := &bytes.Buffer{}
-	encode(_topic, buf)
-	msgr.broadcastMessage("", buf.Bytes(), subscribe)
+	msgr.SubscribeGroup(_topic, string(defaultGroup), handler)
 }
 
 func (msgr *messenger) Unsubscribe(_topic string) {
-	delete(msgr.subscriptions, topic(_topic))
-	buf := &bytes.Buffer{}
-	encode(_topic, buf)
-	msgr.broadcastMessage("", buf.Bytes(), unsubscribe)
+	msgr.UnsubscribeGroup(_topic, string(defaultGroup))
 }
 
-func (msgr *messenger) sendMessage(topic topic, body []byte, msgType messageType) ([]byte, MessageId, error) {
-	msg := &message{
-		MessageId:   newId(),
-		MessageType: msgType,
-		Topic:       topic,
-		Body:        body,
+func (msgr *messenger) SubscribeGroup(_topic, _group string, handler Handler) {
+	msgr.SubscribeMessages(_topic, _group, handler.messageHandler())
+}
+
+func (msgr *messenger) SubscribeMessages(_topic, _group string, handler MessageHandler) {
+	msgr.SubscribeMode(_topic, _group, Unbounded(), handler)
+}
+
+func (msgr *messenger) SubscribeMode(_topic, _group string, mode ExecutionMode, handler MessageHandler) {
+	sub := &subscription{handler: handler, executor: newExecutor(mode)}
+	msgr.Send(subscribeEvent{group: group(_group), topic: topic(_topic), sub: sub})
+	msgr.broadcastMessage(context.Background(), newSubscribeMessage(subscribe, topic(_topic), group(_group)))
+}
+
+func (msgr *messenger) UnsubscribeGroup(_topic, _group string) {
+	msgr.Send(subscribeEvent{group: group(_group), topic: topic(_topic)})
+	msgr.broadcastMessage(context.Background(), newSubscribeMessage(unsubscribe, topic(_topic), group(_group)))
+}
+
+// handleSubscribe replaces the subscription of g to t, or removes it if event.sub is nil.
+func (msgr *messenger) handleSubscribe(event subscribeEvent) {
+	g, t := event.group, event.topic
+	if sub := msgr.subscriptions[g][t]; sub != nil {
+		sub.stop()
 	}
-	for {
-		reply := future.NewFuture()
-		time.AfterFunc(Timeout, func() { reply.SetError(TimeoutError) })
-		msgr.Send("send-message", msg, reply)
-		replyMsg := reply.Value()
-		err := reply.Error()
-		if replyMsg != nil {
-			return replyMsg.(*message).Body, msg.MessageId, nil
-		} else if err == ServerDisconnectedError {
-			continue
-		} else {
-			return nil, msg.MessageId, err
+	if event.sub == nil {
+		delete(msgr.subscriptions[g], t)
+		if len(msgr.subscriptions[g]) == 0 {
+			delete(msgr.subscriptions, g)
 		}
+		return
+	}
+	subs := msgr.subscriptions[g]
+	if subs == nil {
+		subs = make(map[topic]*subscription)
+		msgr.subscriptions[g] = subs
 	}
+	subs[t] = event.sub
 }
 
-func (msgr *messenger) broadcastMessage(topic topic, body []byte, msgType messageType) ([][]byte, MessageId, error) {
-	msg := &message{
+// newSubscribeMessage announces a subscription change. The body is the topic
+// alone, as peers that predate queue groups expect; a named group travels in Groups.
+func newSubscribeMessage(msgType messageType, t topic, g group) *message {
+	buf := &bytes.Buffer{}
+	encode(&ch, t, buf)
+	msg := newMessage(context.Background(), "", buf.Bytes(), msgType)
+	if g != defaultGroup {
+		msg.Groups = []group{g}
+	}
+	return msg
+}
+
+// subscribedGroup returns the group of a subscribe or unsubscribe message.
+func subscribedGroup(msg *message) group {
+	if len(msg.Groups) > 0 {
+		return msg.Groups[0]
+	}
+	return defaultGroup
+}
+
+func newMessage(ctx context.Context, topic topic, body []byte, msgType messageType) *message {
+	return &message{
 		MessageId:   newId(),
 		MessageType: msgType,
 		Topic:       topic,
 		Body:        body,
+		Headers:     headersFromContext(ctx),
 	}
-	replies := future.NewFuture()
-	msgr.Send("broadcast-message", msg, replies)
-	responses := replies.Value().([]future.Future)
-	time.AfterFunc(Timeout, func() {
-		for _, reply := range responses {
-			reply.SetError(TimeoutError)
+}
+
+// sendMessage routes msg to the subscribers of its topic: by consistent hashing
+// when key is not empty, by the topic's Balancer otherwise.
+func (msgr *messenger) sendMessage(ctx context.Context, msg *message, key string) (*message, error) {
+	result := future.NewTyped[*message]()
+	msgr.sendAsync(ctx, msg, key, setReply(result))
+	return result.Wait(context.Background())
+}
+
+// sendAsync is sendMessage that calls done with the outcome instead of waiting for it.
+// The messenger completes done when the reply arrives or the send fails; ctx ending
+// or the timeout completes it otherwise. No goroutine waits in the meantime.
+func (msgr *messenger) sendAsync(ctx context.Context, msg *message, key string, done func(*message, error)) {
+	ctx, cancel := context.WithTimeoutCause(ctx, msgr.Timeout, TimeoutError)
+	start := time.Now()
+	span := msgr.startSendSpan(ctx, msg)
+	var finished atomic.Bool
+	finish := func(reply *message, err error) {
+		if !finished.CompareAndSwap(false, true) {
+			return
+		}
+		cancel()
+		msgr.reportSent(msg, msg.MessageType.String(), start, err)
+		endSpan(span, err)
+		done(reply, err)
+	}
+	if err := msgr.checkMessageSize(msg); err != nil {
+		finish(nil, err)
+		return
+	}
+	if ctx.Err() != nil {
+		// Already canceled or past its deadline: nothing is sent.
+		finish(nil, context.Cause(ctx))
+		return
+	}
+	msgr.Send(sendMessageEvent{msg: msg, key: key, done: finish})
+	// Registered after the send is queued, so that the cancel always follows it.
+	context.AfterFunc(ctx, func() {
+		if !finished.Load() {
+			finish(nil, context.Cause(ctx))
+			msgr.Send(cancelMessageEvent{msgId: msg.MessageId})
 		}
 	})
-	var bodies [][]byte
-	var err error
-	for _, reply := range responses {
-		replyMsg := reply.Value()
-		err := reply.Error()
-		if replyMsg != nil {
-			bodies = append(bodies, replyMsg.(*message).Body)
-		} else if err == nil {
-			err = reply.Error()
+}
+
+func (msgr *messenger) broadcastMessage(ctx context.Context, msg *message) (bodies [][]byte, err error) {
+	finished := make(chan struct{})
+	msgr.broadcastAsync(ctx, msg, func(b [][]byte, e error) {
+		bodies, err = b, e
+		close(finished)
+	})
+	<-finished
+	return bodies, err
+}
+
+// broadcastAsync is broadcastMessage that calls done with the outcome instead of waiting for it.
+func (msgr *messenger) broadcastAsync(ctx context.Context, msg *message, done func([][]byte, error)) {
+	ctx, cancel := context.WithTimeoutCause(ctx, msgr.Timeout, TimeoutError)
+	finish := func(bodies [][]byte, err error) {
+		cancel()
+		done(bodies, err)
+	}
+	if msg.MessageType == publish || msg.MessageType == request {
+		kind := "broadcast"
+		if msg.MessageType == request {
+			kind = "survey"
+		}
+		start := time.Now()
+		span := msgr.startSendSpan(ctx, msg)
+		finish = func(bodies [][]byte, err error) {
+			cancel()
+			msgr.reportSent(msg, kind, start, err)
+			endSpan(span, err)
+			done(bodies, err)
 		}
 	}
-	return bodies, msg.MessageId, err
+	if err := msgr.checkMessageSize(msg); err != nil {
+		finish(nil, err)
+		return
+	}
+	if ctx.Err() != nil {
+		finish(nil, context.Cause(ctx))
+		return
+	}
+	survey := newSurvey(finish)
+	msgr.Send(broadcastMessageEvent{msg: msg, survey: survey})
+	context.AfterFunc(ctx, func() {
+		if survey.expire(context.Cause(ctx)) {
+			msgr.Send(cancelMessageEvent{msgId: msg.MessageId})
+		}
+	})
 }
 
-func (msgr *messenger) handleDial(_ string, info []interface{}) {
-	peerId := info[0].(hostId)
-	var result future.Future
-	if len(info) > 1 {
-		result = info[1].(future.Future)
+// checkMessageSize refuses bodies that no peer with the same limit accepts.
+func (msgr *messenger) checkMessageSize(msg *message) error {
+	if len(msg.Body) > msgr.MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", MessageTooLargeError, len(msg.Body), msgr.MaxMessageSize)
 	}
+	return nil
+}
+
+func (msgr *messenger) handleDial(event dialEvent) {
+	peerId, result := event.peerId, event.result
 	if peerId != msgr.hostId {
-		_, found := msgr.peers[peerId]
+		peer, found := msgr.peers[peerId]
 		if !found {
 			msgr.peers[peerId] = msgr.newPeer(peerId)
-			msgr.dialer.Send("dial", peerId, msgr.newJoinMessage(), result)
+			msgr.dialer.Send(dialRequest{peerId: peerId, joinMsg: msgr.newJoinMessage(), result: result})
+			return
+		}
+		if peer.state != peerConnected && result != nil {
+			// Already being dialed or accepted; let Join wait for that to finish.
+			peer.joinResults = append(peer.joinResults, result)
+			return
 		}
 	}
+	if result != nil {
+		result.SetValue(true)
+	}
 }
 
-func (msgr *messenger) handleConnected(msgType string, info []interface{}) {
-	conn := info[0].(net.Conn)
-	reply := info[1].(*joinMessage)
+func (msgr *messenger) handleConnected(event connectedEvent) {
+	conn, reply := event.conn, event.joinMsg
+	msgType := "dialed"
+	if event.accepted {
+		msgType = "accepted"
+	}
+
+	if err := checkProtocol(reply); err != nil {
+		msgr.Log.Errorf("Peer %s refused: %v", reply.HostId, err)
+		if event.accepted {
+			// Let the dialer see our versions and refuse us in turn instead of re-dialing.
+			msgr.writeJoinReply(conn, defaultCodec, nil)
+		}
+		conn.Close()
+		if peer, found := msgr.peers[reply.HostId]; found && peer.state != peerConnected {
+			msgr.Send(shutdownPeerEvent{peerId: peer.peerId})
+		}
+		return
+	}
 
-	var result future.Future
-	if len(info) > 3 && info[3] != nil {
-		result = info[3].(future.Future)
-		defer result.SetValue(true)
+	certs := peerCertificates(conn)
+	if msgr.VerifyPeer != nil {
+		if err := msgr.VerifyPeer(string(reply.HostId), certs); err != nil {
+			msgr.Log.Errorf("Peer %s rejected: %v", reply.HostId, err)
+			conn.Close()
+			if peer, found := msgr.peers[reply.HostId]; found && peer.state != peerConnected {
+				msgr.Send(shutdownPeerEvent{peerId: peer.peerId})
+			}
+			return
+		}
 	}
 
 	peer, found := msgr.peers[reply.HostId]
@@ -318,59 +632,75 @@ func (msgr *messenger) handleConnected(msgType string, info []interface{}) {
 		msgr.peers[reply.HostId] = peer
 	}
 
-	peer.setConn(conn).setTopics(reply.Topics)
-
-	if msgType == "accepted" {
-		buf := &bytes.Buffer{}
-		encode(msgr.newJoinMessage(), buf)
-		msg := &message{
-			MessageId:   newId(),
-			MessageType: join,
-			Body:        buf.Bytes(),
+	if event.accepted {
+		peer.codec = msgr.chooseCodec(reply.Codecs)
+		peer.compressor = msgr.chooseCompressor(reply.Compressors)
+	} else {
+		if peer.codec = msgr.codecByName(reply.Codec); peer.codec == nil {
+			peer.codec = defaultCodec
 		}
+		peer.compressor = msgr.compressorByName(reply.Compressor)
+	}
+	peer.maxMessageSize = reply.MaxMessage
+	peer.setCapabilities(reply.Capabilities).setConn(conn).setTopics(reply.Topics, reply.Groups)
+	peer.certificates = certs
 
-		err := writeMessage(conn, msg)
-		if err != nil {
-			msgr.Send("shutdown-peer", peer.peerId)
+	if event.accepted {
+		if err := msgr.writeJoinReply(conn, peer.codec, peer.compressor); err != nil {
+			msgr.Send(shutdownPeerEvent{peerId: peer.peerId})
 			return
 		}
 	}
 
 	peer.state = peerConnected
-	Log.Infof("Peer %s joined. (%s)", peer.peerId, msgType)
+	msgr.reportPeers()
+	msgr.ring.add(peer.peerId)
+	peer.completeJoin()
+	if len(certs) > 0 {
+		msgr.Log.Infof("Peer %s joined as %q. (%s)", peer.peerId, certs[0].Subject.CommonName, msgType)
+	} else {
+		msgr.Log.Infof("Peer %s joined. (%s)", peer.peerId, msgType)
+	}
 
 	for _, peerId := range reply.Peers {
 		if _, found := msgr.peers[peerId]; !found {
-			msgr.Send("dial", peerId)
+			msgr.Send(dialEvent{peerId: peerId})
 		}
 	}
 }
 
-func (msgr *messenger) handleShutdownPeer(_ string, info []interface{}) {
-	peerId := info[0].(hostId)
-	peer := msgr.peers[peerId]
+func (msgr *messenger) handleShutdownPeer(event shutdownPeerEvent) {
+	peer := msgr.peers[event.peerId]
 	if peer == nil {
 		return
 	}
 
-	// Log.Debugf("stopPeer: disconnected: %d", len(peer.pendingReplies))
+	delete(msgr.peers, peer.peerId)
+	msgr.ring.remove(peer.peerId)
+	disconnected := ServerDisconnectedError
+	if isProtocolError(peer.failure) {
+		// Not ServerDisconnectedError itself, so the message is not sent again.
+		disconnected = fmt.Errorf("%w: %v", ServerDisconnectedError, peer.failure)
+	}
 	for _, pending := range peer.pendingReplies {
-		pending.SetError(ServerDisconnectedError)
+		pending.done(nil, disconnected)
 	}
-	delete(msgr.peers, peer.peerId)
+	msgr.Metrics.Delete("envoy_pending_replies", peerLabels(peer.peerId))
+	msgr.reportPeers()
+	peer.completeJoin()
 	peer.pendingReplies = nil
 	if peer.conn != nil {
 		if peer.state == peerLeaving {
-			peer.writer.Send("write", &message{MessageType: left})
+			peer.write(&message{MessageType: left})
 		}
 		peer.reader.Stop()
 		peer.writer.Stop()
 		peer.conn.Close()
 	}
-	msgr.Send("shutdown-messenger")
+	msgr.Send(shutdownMessengerEvent{})
 }
 
-func (msgr *messenger) handleShutdownMessenger(msgType string, info []interface{}) {
+func (msgr *messenger) handleShutdownMessenger(shutdownMessengerEvent) {
 	if msgr.state == messengerLeaving && msgr.leaveFuture != nil && len(msgr.peers) == 0 {
 		msgr.leaveFuture.SetValue(true)
 	}
@@ -381,33 +711,139 @@ func (msgr *messenger) newPeer(hostId hostId) *peer {
 		msgr:           msgr,
 		msgrId:         msgr.hostId,
 		peerId:         hostId,
-		topics:         make(map[topic]struct{}),
-		pendingReplies: make(map[messageId]future.Future),
+		topics:         make(map[group]map[topic]struct{}),
+		pendingReplies: make(map[messageId]*pendingReply),
 	}
 	return peer
 }
 
+// expectReply has done called with the peer's reply to msgId, or with the error that ends the wait.
+func (peer *peer) expectReply(msgId messageId, done func(*message, error)) {
+	peer.pendingReplies[msgId] = &pendingReply{sent: time.Now(), done: done}
+	peer.reportPendingReplies()
+}
+
+// completeReply ends the wait for the peer's reply to msgId.
+// It returns false if nobody was waiting.
+func (peer *peer) completeReply(msgId messageId, reply *message, err error) bool {
+	pending, found := peer.pendingReplies[msgId]
+	if !found {
+		return false
+	}
+	delete(peer.pendingReplies, msgId)
+	peer.reportPendingReplies()
+	pending.done(reply, err)
+	peer.stopWhenDrained()
+	return true
+}
+
+// replied is completeReply for the peer's reply to msgId, recording how long it took.
+func (peer *peer) replied(msgId messageId, reply *message, err error) bool {
+	if pending, found := peer.pendingReplies[msgId]; found {
+		peer.msgr.Metrics.Observe("envoy_reply_duration_seconds", peerLabels(peer.peerId), time.Since(pending.sent).Seconds())
+	}
+	return peer.completeReply(msgId, reply, err)
+}
+
+func (peer *peer) completeJoin() {
+	for _, result := range peer.joinResults {
+		result.SetValue(peer.state == peerConnected)
+	}
+	peer.joinResults = nil
+}
+
 func (peer *peer) setConn(conn net.Conn) *peer {
 	peer.conn = conn
-	peer.reader = newReader(fmt.Sprintf("%s-%s-reader", peer.msgrId, peer.peerId), peer.peerId, peer.conn, peer.msgr)
-	peer.writer = newWriter(fmt.Sprintf("%s-%s-writer", peer.msgrId, peer.peerId), peer.peerId, peer.conn, peer.msgr)
+	metered := meteredConn{Conn: conn, metrics: peer.msgr.Metrics, labels: peerLabels(peer.peerId)}
+	supervisor := peer.msgr.peerSupervisor(peer.peerId)
+	peer.reader = newReader(fmt.Sprintf("%s-%s-reader", peer.msgrId, peer.peerId), peer.peerId, metered, peer.codec, peer.compressor, peer.idleTimeout(), peer.msgr, supervisor, &peer.msgr.Options)
+	peer.writer = newWriter(fmt.Sprintf("%s-%s-writer", peer.msgrId, peer.peerId), peer.peerId, metered, peer.codec, peer.compressor, peer.msgr, peer.supports(capParts), supervisor, &peer.msgr.Options)
 	return peer
 }
 
-func (peer *peer) setTopics(topics []topic) *peer {
+// write queues msg for the peer's writer without ever blocking the messenger.
+// When the queue is full, a publish or request fails alone; any other frame,
+// which the peer would wait for in vain, fails the peer.
+func (peer *peer) write(msg *message) {
+	adapted := peer.adapt(msg)
+	if adapted == nil {
+		// Not for this peer; there is no write to wait for.
+		peer.completeReply(msg.MessageId, nil, nil)
+		return
+	}
+	if err := peer.checkMessageSize(msg.Body); err != nil && peer.completeReply(msg.MessageId, nil, err) {
+		return
+	}
+	err := peer.writer.TrySend(writeRequest{msg: adapted})
+	if err == nil {
+		return
+	}
+	err = fmt.Errorf("writing to %s: %w", peer.peerId, err)
+	switch msg.MessageType {
+	case publish, request:
+		if peer.completeReply(msg.MessageId, nil, err) {
+			return
+		}
+	case reply, replyPanic, replyError, replyRejected, ping, pong, left:
+		// Losing these costs the peer a timed out request or a missed heartbeat,
+		// not the connection; frames that change the peer's state do.
+		peer.msgr.Metrics.Add("envoy_dropped_frames_total", Labels{"peer": string(peer.peerId), "kind": msg.MessageType.String()}, 1)
+		peer.msgr.Log.Errorf("Write queue for %s is full. Dropped '%s' message.", peer.peerId, msg.MessageType)
+		return
+	}
+	peer.msgr.Send(networkErrorEvent{peerId: peer.peerId, err: err})
+}
+
+// checkMessageSize refuses a body above the limit the peer advertised,
+// which the peer would take for a protocol error.
+func (peer *peer) checkMessageSize(body []byte) error {
+	if peer.maxMessageSize > 0 && len(body) > peer.maxMessageSize {
+		return fmt.Errorf("%w: %d bytes for %s, limit %d", MessageTooLargeError, len(body), peer.peerId, peer.maxMessageSize)
+	}
+	return nil
+}
+
+// writeBlocking is write for goroutines other than the messenger's, such as
+// handlers replying to the peer. It waits for room in a full queue, slowing
+// them down to the peer's pace.
+func (peer *peer) writeBlocking(msg *message) {
+	if adapted := peer.adapt(msg); adapted != nil {
+		peer.writer.Send(writeRequest{msg: adapted})
+	}
+}
+
+func (peer *peer) setTopics(topics []topic, groups []subscribeMessageBody) *peer {
 	for _, t := range topics {
-		peer.topics[t] = struct{}{}
+		peer.addTopic(defaultGroup, t)
+	}
+	for _, sub := range groups {
+		peer.addTopic(sub.Group, sub.Topic)
 	}
 	return peer
 }
 
-func (msgr *messenger) handleMessage(_ string, info []interface{}) {
-	from := info[0].(hostId)
-	msg := info[1].(*message)
+func (peer *peer) addTopic(g group, t topic) {
+	topics := peer.topics[g]
+	if topics == nil {
+		topics = make(map[topic]struct{})
+		peer.topics[g] = topics
+	}
+	topics[t] = struct{}{}
+}
+
+func (peer *peer) removeTopic(g group, t topic) {
+	delete(peer.topics[g], t)
+	if len(peer.topics[g]) == 0 {
+		delete(peer.topics, g)
+	}
+}
+
+func (msgr *messenger) handleMessage(event messageEvent) {
+	from, msg := event.from, event.msg
 
 	peer := msgr.peers[from]
 	if peer == nil {
-		Log.Errorf("Received '%s' message for non-existing peer %s. Ignored.", msg.MessageType, from)
+		msgr.Log.Errorf("Received '%s' message for non-existing peer %s. Ignored.", msg.MessageType, from)
 		return
 	}
 
@@ -418,6 +854,10 @@ func (msgr *messenger) handleMessage(_ string, info []interface{}) {
 		msgr.handleReply(peer, msg)
 	case replyPanic:
 		msgr.handleReplyPanic(peer, msg)
+	case replyError:
+		msgr.handleReplyError(peer, msg)
+	case replyRejected:
+		msgr.handleReplyRejected(peer, msg)
 	case subscribe:
 		msgr.handleSubscribed(peer, msg)
 	case unsubscribe:
@@ -426,16 +866,19 @@ func (msgr *messenger) handleMessage(_ string, info []interface{}) {
 		msgr.handleLeaving(peer, msg)
 	case left:
 		msgr.handleLeft(peer, msg)
+	case ping:
+		msgr.handlePing(peer, msg)
+	case pong:
+		// Arriving is all a pong has to do.
 	default:
-		panic(fmt.Sprintf("received message: %v", msg))
+		msgr.Log.Errorf("Received unsupported message type %d from %s. Ignored.", msg.MessageType, peer.peerId)
 	}
 }
 
-func (msgr *messenger) handleNetworkError(_ string, info []interface{}) {
-	peerId := info[0].(hostId)
-	err := info[1].(error)
+func (msgr *messenger) handleNetworkError(event networkErrorEvent) {
+	peerId, err := event.peerId, event.err
 	if msgr.state == messengerLeaving {
-		msgr.Send("shutdown-peer", peerId)
+		msgr.Send(shutdownPeerEvent{peerId: peerId})
 		return
 	}
 	if peer, found := msgr.peers[peerId]; found {
@@ -443,26 +886,32 @@ func (msgr *messenger) handleNetworkError(_ string, info []interface{}) {
 			return
 		}
 		peer.state = peerStopping
+		peer.failure = err
 		if err.Error() == "EOF" {
-			Log.Errorf("Peer %s disconnected. Will try to re-connect.", peerId)
+			msgr.Log.Errorf("Peer %s disconnected. Will try to re-connect.", peerId)
+		} else if errors.Is(err, os.ErrDeadlineExceeded) {
+			msgr.Log.Errorf("Peer %s: Connection timed out: %v. Will try to re-connect.", peerId, err)
+		} else if isProtocolError(err) {
+			msgr.Log.Errorf("Peer %s: Protocol error: %v. Disconnecting; will try to re-connect.", peerId, err)
 		} else {
-			Log.Errorf("Peer %s: Network error: %v. Will try to re-connect.", peerId, err)
+			msgr.Log.Errorf("Peer %s: Network error: %v. Will try to re-connect.", peerId, err)
 		}
 
-		msgr.Send("shutdown-peer", peer.peerId)
+		msgr.Send(shutdownPeerEvent{peerId: peer.peerId})
 
+		msgr.Metrics.Add("envoy_redials_total", peerLabels(peerId), 1)
 		if peerId > msgr.hostId {
-			msgr.Send("dial", peerId)
+			msgr.Send(dialEvent{peerId: peerId})
 		} else {
-			time.AfterFunc(RedialInterval, func() {
-				msgr.Send("dial", peerId)
+			time.AfterFunc(msgr.RedialInterval, func() {
+				msgr.Send(dialEvent{peerId: peerId})
 			})
 		}
 	}
 }
 
-func (msgr *messenger) handleDialError(_ string, info []interface{}) {
-	peerId := info[0].(hostId)
+func (msgr *messenger) handleDialError(event dialErrorEvent) {
+	peerId := event.peerId
 	peer := msgr.peers[peerId]
 	if peer != nil {
 		if peer.conn != nil {
@@ -470,205 +919,493 @@ func (msgr *messenger) handleDialError(_ string, info []interface{}) {
 				return
 			}
 		}
-		msgr.Send("shutdown-peer", peer.peerId)
+		msgr.Send(shutdownPeerEvent{peerId: peer.peerId})
 	}
-	Log.Errorf("Failed to dial %s. Will re-dial.", peerId)
-	time.AfterFunc(RedialInterval, func() {
-		msgr.Send("dial", peerId)
+	msgr.Log.Errorf("Failed to dial %s. Will re-dial.", peerId)
+	msgr.Metrics.Add("envoy_redials_total", peerLabels(peerId), 1)
+	time.AfterFunc(msgr.RedialInterval, func() {
+		msgr.Send(dialEvent{peerId: peerId})
 	})
 }
 
 func (msgr *messenger) handleRequest(peer *peer, msg *message) {
-	handler := msgr.subscriptions[msg.Topic]
-	if handler == nil {
-		Log.Errorf("Received '%s' message for non-subscribed topic %s. Ignored.", msg.MessageType, msg.Topic)
-		return
+	groups := msg.Groups
+	if len(groups) == 0 {
+		groups = []group{defaultGroup}
+	}
+	for _, g := range groups {
+		sub := msgr.getSubscription(g, msg.Topic)
+		if sub == nil {
+			msgr.Log.Errorf("Received '%s' message for non-subscribed topic %s (group '%s'). Ignored.", msg.MessageType, msg.Topic, g)
+			continue
+		}
+
+		msgr.Metrics.Add("envoy_messages_received_total", Labels{"topic": string(msg.Topic), "kind": msg.MessageType.String()}, 1)
+		reject := func() { msgr.rejectRequest(peer, msg) }
+		if !sub.submit(func() { msgr.runHandler(peer, msg, sub.handler) }, reject) {
+			msgr.Log.Errorf("Handler queue for topic %s (group '%s') is full. Rejected '%s' message.", msg.Topic, g, msg.MessageType)
+			reject()
+		}
 	}
+}
+
+// rejectRequest answers a request that no handler will run with replyRejected.
+// Published messages are dropped.
+func (msgr *messenger) rejectRequest(peer *peer, msg *message) {
+	msgr.Metrics.Add("envoy_rejected_total", topicLabels(msg.Topic), 1)
+	if msg.MessageType == request {
+		peer.write(&message{MessageId: msg.MessageId, MessageType: replyRejected})
+	}
+}
 
-	go msgr.runHandler(peer, msg, handler)
+func (msgr *messenger) getSubscription(g group, t topic) *subscription {
+	subs := msgr.subscriptions[g]
+	if pattern, found := bestMatch(subs, t); found {
+		return subs[pattern]
+	}
+	return nil
 }
 
 func (msgr *messenger) handleReply(peer *peer, msg *message) {
-	result := peer.pendingReplies[msg.MessageId]
-	delete(peer.pendingReplies, msg.MessageId)
-	if result == nil {
-		Log.Errorf("Received unexpected reply for '%s'. Ignored.", msg.Topic)
-		return
+	if !peer.replied(msg.MessageId, msg, nil) {
+		msgr.Log.Errorf("Received unexpected reply for '%s'. Ignored.", msg.Topic)
 	}
-	result.SetValue(msg)
 }
 
 func (msgr *messenger) handleReplyPanic(peer *peer, msg *message) {
-	result := peer.pendingReplies[msg.MessageId]
-	delete(peer.pendingReplies, msg.MessageId)
-	result.SetError(PanicError)
+	if !peer.replied(msg.MessageId, nil, PanicError) {
+		msgr.Log.Errorf("Received unexpected panic reply for '%s'. Ignored.", msg.Topic)
+	}
+}
+
+func (msgr *messenger) handleReplyError(peer *peer, msg *message) {
+	if _, found := peer.pendingReplies[msg.MessageId]; !found {
+		msgr.Log.Errorf("Received unexpected error reply for '%s'. Ignored.", msg.Topic)
+		return
+	}
+	remoteErr := &RemoteError{}
+	if err := decode(&ch, bytes.NewBuffer(msg.Body), remoteErr); err != nil {
+		peer.replied(msg.MessageId, nil, err)
+		msgr.protocolError(peer, err)
+		return
+	}
+	peer.replied(msg.MessageId, nil, remoteErr)
+}
+
+func (msgr *messenger) handleReplyRejected(peer *peer, msg *message) {
+	if !peer.replied(msg.MessageId, nil, RejectedError) {
+		msgr.Log.Errorf("Received unexpected rejected reply for '%s'. Ignored.", msg.Topic)
+	}
 }
 
 func (msgr *messenger) handleSubscribed(peer *peer, msg *message) {
-	buf := bytes.NewBuffer(msg.Body)
 	var t topic
-	decode(buf, &t)
-	peer.topics[t] = struct{}{}
+	if err := decode(&ch, bytes.NewBuffer(msg.Body), &t); err != nil {
+		msgr.protocolError(peer, err)
+		return
+	}
+	peer.addTopic(subscribedGroup(msg), t)
 }
 
 func (msgr *messenger) handleUnsubscribed(peer *peer, msg *message) {
-	buf := bytes.NewBuffer(msg.Body)
 	var t topic
-	decode(buf, &t)
-	delete(peer.topics, t)
+	if err := decode(&ch, bytes.NewBuffer(msg.Body), &t); err != nil {
+		msgr.protocolError(peer, err)
+		return
+	}
+	peer.removeTopic(subscribedGroup(msg), t)
+}
+
+func isProtocolError(err error) bool {
+	return errors.Is(err, MalformedFrameError) || errors.Is(err, FrameTooLargeError)
+}
+
+// protocolError disconnects a peer that sent a message that cannot be decoded.
+// The peer is re-dialed like after any other network error.
+func (msgr *messenger) protocolError(peer *peer, err error) {
+	msgr.Send(networkErrorEvent{peerId: peer.peerId, err: err})
 }
 
 func (msgr *messenger) handleLeaving(peer *peer, msg *message) {
 	peer.state = peerLeaving
-
-	pendingFutures := make([]future.Future, len(peer.pendingReplies))
-	for _, pf := range peer.pendingReplies {
-		pendingFutures = append(pendingFutures, pf)
-	}
-	go stopPeer(peer, pendingFutures, msgr)
+	peer.draining = true
+	peer.stopWhenDrained()
 }
 
 func (msgr *messenger) handleLeft(peer *peer, msg *message) {
 	peer.state = peerStopping
-	if peer == nil {
-		return
-	}
-
-	pendingFutures := make([]future.Future, len(peer.pendingReplies))
-	for _, pf := range peer.pendingReplies {
-		pendingFutures = append(pendingFutures, pf)
-	}
-	go stopPeer(peer, pendingFutures, msgr)
+	peer.draining = true
+	peer.stopWhenDrained()
 }
 
-func stopPeer(peer *peer, pendingFutures []future.Future, msgr actor.Actor) {
-	for _, pf := range pendingFutures {
-		if pf != nil {
-			pf.Value()
-		}
+// stopWhenDrained shuts down a draining peer once it owes no more replies.
+func (peer *peer) stopWhenDrained() {
+	if !peer.draining || len(peer.pendingReplies) > 0 {
+		return
 	}
-	msgr.Send("shutdown-peer", peer.peerId)
+	peer.draining = false
+	peer.msgr.Send(shutdownPeerEvent{peerId: peer.peerId})
 	if peer.state == peerLeaving {
-		Log.Infof("Peer %s left.", peer.peerId)
+		peer.msgr.Log.Infof("Peer %s left.", peer.peerId)
 	}
-	peer.writer.Send("write", &message{MessageType: left})
-
+	peer.write(&message{MessageType: left})
 }
 
-func (msgr *messenger) runHandler(peer *peer, msg *message, handler Handler) {
-	result, err := msgr.runHandlerProtected(msg, handler)
+func (msgr *messenger) runHandler(peer *peer, msg *message, handler MessageHandler) {
+	in := &Message{
+		Topic:        string(msg.Topic),
+		Headers:      msg.Headers,
+		Body:         msg.Body,
+		ReplyHeaders: Headers{},
+	}
+	span := msgr.startHandlerSpan(msg, in)
+	result, err := msgr.runHandlerProtected(in, handler)
+	endSpan(span, err)
 	if msg.MessageType == publish {
+		if err != nil && err != PanicError {
+			msgr.Log.Errorf("Handler for '%s' failed: %v", msg.Topic, err)
+		}
 		return
 	}
+	if err == nil {
+		if err = peer.checkMessageSize(result); err != nil {
+			msgr.Log.Errorf("Reply to '%s' failed: %v", msg.Topic, err)
+		}
+	}
 
 	reply := &message{
 		MessageId:   msg.MessageId,
 		MessageType: reply,
 		Body:        result,
 	}
+	if len(in.ReplyHeaders) > 0 {
+		reply.Headers = in.ReplyHeaders
+	}
 
 	if err == PanicError {
 		reply.MessageType = replyPanic
+	} else if err != nil {
+		buf := &bytes.Buffer{}
+		encode(&ch, toRemoteError(err), buf)
+		reply.MessageType = replyError
+		reply.Body = buf.Bytes()
+	}
+
+	peer.writeBlocking(reply)
+}
+
+// runLocalHandler runs a Broadcast or Survey on the local messenger and
+// calls done the way a peer's reply would.
+func (msgr *messenger) runLocalHandler(msg *message, handler MessageHandler, done func(*message, error)) {
+	in := &Message{
+		Topic:        string(msg.Topic),
+		Headers:      msg.Headers,
+		Body:         msg.Body,
+		ReplyHeaders: Headers{},
+	}
+	span := msgr.startHandlerSpan(msg, in)
+	result, err := msgr.runHandlerProtected(in, handler)
+	endSpan(span, err)
+	if msg.MessageType == publish {
+		if err != nil && err != PanicError {
+			msgr.Log.Errorf("Handler for '%s' failed: %v", msg.Topic, err)
+		}
+		return
 	}
 
-	peer.writer.Send("write", reply)
+	if err == PanicError {
+		done(nil, PanicError)
+	} else if err != nil {
+		done(nil, toRemoteError(err))
+	} else {
+		done(&message{MessageId: msg.MessageId, MessageType: reply, Body: result, Headers: in.ReplyHeaders}, nil)
+	}
 }
 
-func (msgr *messenger) runHandlerProtected(msg *message, handler Handler) (result []byte, err error) {
+func (msgr *messenger) runHandlerProtected(msg *Message, handler MessageHandler) (result []byte, err error) {
+	start := time.Now()
 	defer func() {
 		recErr := recover()
 		if recErr != nil {
-			Log.Panic(recErr, string(debug.Stack()))
+			msgr.Log.Panic(recErr, string(debug.Stack()))
 			result = nil
 			err = PanicError
 		}
+		labels := Labels{"topic": msg.Topic}
+		msgr.Metrics.Observe("envoy_handler_duration_seconds", labels, time.Since(start).Seconds())
+		if err == PanicError {
+			msgr.Metrics.Add("envoy_handler_panics_total", labels, 1)
+		} else if err != nil {
+			msgr.Metrics.Add("envoy_handler_errors_total", labels, 1)
+		}
 	}()
 
-	result = handler(string(msg.Topic), msg.Body)
-	return result, err
+	return handler(msg)
 
 }
 
-func (msgr *messenger) handleWriteResult(_ string, info []interface{}) {
-	peerId := info[0].(hostId)
-	msg := info[1].(*message)
-	var err error
-	if len(info) > 2 && info[2] != nil {
-		err = info[2].(error)
-	}
+func (msgr *messenger) handleWriteResult(event writeResultEvent) {
+	peerId, msg, err := event.peerId, event.msg, event.err
 
 	peer := msgr.peers[peerId]
 	if peer != nil {
 		if err != nil {
-			msgr.Send("shutdown-peer", peer.peerId)
-			if peerId > msgr.hostId {
-				msgr.Send("dial", peerId)
-			}
+			msgr.Send(networkErrorEvent{peerId: peerId, err: err})
 		}
 
 		if msg.MessageType == publish || msg.MessageType == subscribe || msg.MessageType == unsubscribe {
-			if reply, exists := peer.pendingReplies[msg.MessageId]; exists {
-				delete(peer.pendingReplies, msg.MessageId)
-				reply.SetError(err)
-			}
+			peer.completeReply(msg.MessageId, nil, err)
 		}
 	}
 }
 
 func (msgr *messenger) getTopics() []topic {
-	topics := make([]topic, 0, len(msgr.subscriptions))
-	for topic := range msgr.subscriptions {
+	topics := make([]topic, 0, len(msgr.subscriptions[defaultGroup]))
+	for topic := range msgr.subscriptions[defaultGroup] {
 		topics = append(topics, topic)
 	}
 	return topics
 }
 
-func (msgr *messenger) handleSendMessage(_ string, info []interface{}) {
-	msg := info[0].(*message)
-	reply := info[1].(future.Future)
+func (msgr *messenger) handleSendMessage(event sendMessageEvent) {
+	msgr.dispatch(event.msg, nil, event.key, event.done)
+}
+
+// dispatch sends msg to one server per group subscribed to its topic, or per
+// group in groups if not nil. The groups of a server that disconnects before it
+// answers are dispatched again.
+func (msgr *messenger) dispatch(msg *message, groups []group, key string, done func(*message, error)) {
+	servers := msgr.selectTopicServers(msg, groups, key)
+	if len(servers) == 0 {
+		done(nil, NoSubscribersError)
+		return
+	}
+	serverDone := allReplies(len(servers), done)
+	for server, serverGroups := range servers {
+		serverMsg := *msg
+		if len(serverGroups) > 1 || serverGroups[0] != defaultGroup {
+			serverMsg.Groups = serverGroups
+		}
+		server.expectReply(msg.MessageId, msgr.redispatching(msg, serverGroups, key, serverDone))
+		server.write(&serverMsg)
+	}
+}
+
+// redispatching returns a done func that dispatches msg to groups again
+// if their server disconnected, and passes any other outcome on to done.
+func (msgr *messenger) redispatching(msg *message, groups []group, key string, done func(*message, error)) func(*message, error) {
+	return func(reply *message, err error) {
+		if err == ServerDisconnectedError {
+			msgr.dispatch(msg, groups, key, done)
+			return
+		}
+		done(reply, err)
+	}
+}
+
+// allReplies returns a done func for count servers sent the same message.
+// It passes on the first error, or the last reply once every server answered.
+func allReplies(count int, done func(*message, error)) func(*message, error) {
+	return func(reply *message, err error) {
+		if count == 0 {
+			return
+		}
+		if count--; err != nil {
+			count = 0
+			done(nil, err)
+		} else if count == 0 {
+			done(reply, nil)
+		}
+	}
+}
+
+// setReply returns a done func that completes f.
+func setReply(f future.Typed[*message]) func(*message, error) {
+	return func(reply *message, err error) {
+		if err != nil {
+			f.SetError(err)
+		} else {
+			f.SetValue(reply)
+		}
+	}
+}
+
+func (msgr *messenger) handleBroadcastMessage(event broadcastMessageEvent) {
+	msg, survey := event.msg, event.survey
 
-	server := msgr.selectTopicServer(msg.Topic)
-	if server == nil {
-		reply.SetError(NoSubscribersError)
+	if msg.MessageType != publish && msg.MessageType != request {
+		for _, peer := range msgr.peers {
+			if peer.state == peerConnected {
+				peer.expectReply(msg.MessageId, survey.expect(peer.peerId))
+				peer.write(msg)
+			}
+		}
+		survey.seal(nil)
 		return
 	}
-	server.pendingReplies[msg.MessageId] = reply
-	server.writer.Send("write", msg)
+
+	expected := 0
+	for _, peer := range msgr.peers {
+		if peer.state != peerConnected {
+			continue
+		}
+		groups := broadcastGroups(msg, peer.topics)
+		if len(groups) == 0 {
+			continue
+		}
+		peerMsg := *msg
+		if len(groups) > 1 || groups[0] != defaultGroup {
+			peerMsg.Groups = groups
+		}
+		expected++
+		peer.expectReply(msg.MessageId, survey.expect(peer.peerId))
+		peer.write(&peerMsg)
+	}
+
+	if groups := broadcastGroups(msg, msgr.subscriptions); len(groups) > 0 {
+		expected++
+		done := survey.expect(msgr.hostId)
+		for _, g := range groups {
+			sub := msgr.getSubscription(g, msg.Topic)
+			msgr.Metrics.Add("envoy_messages_received_total", Labels{"topic": string(msg.Topic), "kind": msg.MessageType.String()}, 1)
+			reject := func() {
+				msgr.Metrics.Add("envoy_rejected_total", topicLabels(msg.Topic), 1)
+				done(nil, RejectedError)
+			}
+			if !sub.submit(func() { msgr.runLocalHandler(msg, sub.handler, done) }, reject) {
+				reject()
+			}
+		}
+		if msg.MessageType == publish {
+			done(nil, nil)
+		}
+	}
+
+	if expected == 0 {
+		survey.seal(NoSubscribersError)
+	} else {
+		survey.seal(nil)
+	}
 }
 
-func (msgr *messenger) handleBroadcastMessage(_ string, info []interface{}) {
-	msg := info[0].(*message)
-	replies := info[1].(future.Future)
-	responses := make([]future.Future, 0, len(msgr.peers))
+// broadcastGroups returns the groups of a single node that receive msg:
+// all subscribed groups for publish, the first one for request, so that
+// a node replies to a Survey only once.
+func broadcastGroups[V any](msg *message, subscriptions map[group]map[topic]V) []group {
+	groups := matchingGroups(subscriptions, msg.Topic)
+	if msg.MessageType == request && len(groups) > 1 {
+		groups = groups[:1]
+	}
+	return groups
+}
 
+func (msgr *messenger) handleCancelMessage(event cancelMessageEvent) {
 	for _, peer := range msgr.peers {
-		if peer.state == peerConnected {
-			response := future.NewFuture()
-			responses = append(responses, response)
-			peer.pendingReplies[msg.MessageId] = response
-			peer.writer.Send("write", msg)
+		if _, found := peer.pendingReplies[event.msgId]; found {
+			delete(peer.pendingReplies, event.msgId)
+			peer.reportPendingReplies()
+			peer.stopWhenDrained()
+		}
+	}
+}
+
+// selectTopicServers picks one server per queue group subscribed to msg.Topic,
+// or per group in groups if not nil. Requests go to a single group: the default
+// one if it has members.
+func (msgr *messenger) selectTopicServers(msg *message, groups []group, key string) map[*peer][]group {
+	if groups == nil {
+		groups = msgr.getGroupsByTopic(msg.Topic)
+	}
+	if msg.MessageType == request && len(groups) > 1 {
+		groups = groups[:1]
+	}
+	result := make(map[*peer][]group)
+	for _, g := range groups {
+		if server := msgr.selectTopicServer(g, msg.Topic, key); server != nil {
+			result[server] = append(result[server], g)
+		}
+	}
+	return result
+}
+
+// getGroupsByTopic returns the groups subscribed to t in name order,
+// so the default group comes first.
+func (msgr *messenger) getGroupsByTopic(t topic) []group {
+	found := make(map[group]struct{})
+	for _, server := range msgr.peers {
+		if server.state == peerConnected {
+			for _, g := range matchingGroups(server.topics, t) {
+				found[g] = struct{}{}
+			}
 		}
 	}
-	replies.SetValue(responses)
+	groups := make([]group, 0, len(found))
+	for g := range found {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+	return groups
 }
 
-func (msgr *messenger) selectTopicServer(t topic) *peer {
-	servers := msgr.getServersByTopic(t)
+func (msgr *messenger) selectTopicServer(g group, t topic, key string) *peer {
+	servers := msgr.getServersByTopic(g, t)
 	if len(servers) == 0 {
 		return nil
 	}
-	return servers[mRand.Intn(len(servers))]
+	if key != "" {
+		return msgr.selectKeyServer(servers, key)
+	}
+	candidates := make([]Server, len(servers))
+	for i, server := range servers {
+		candidates[i] = server
+	}
+	return msgr.getBalancer(t).Select(string(t), candidates).(*peer)
+}
+
+func (msgr *messenger) selectKeyServer(servers []*peer, key string) *peer {
+	candidates := make(map[hostId]*peer, len(servers))
+	for _, server := range servers {
+		candidates[server.peerId] = server
+	}
+	id, found := msgr.ring.lookup(key, func(id hostId) bool {
+		_, ok := candidates[id]
+		return ok
+	})
+	if !found {
+		return servers[0]
+	}
+	return candidates[id]
 }
 
-func (msgr *messenger) getServersByTopic(t topic) []*peer {
+func (msgr *messenger) getBalancer(t topic) Balancer {
+	if pattern, found := bestMatch(msgr.topicBalancers, t); found {
+		return msgr.topicBalancers[pattern]
+	}
+	return msgr.Balancer
+}
+
+// getServersByTopic returns the connected members of group g whose most specific
+// pattern matching t is the most specific one across the cluster.
+func (msgr *messenger) getServersByTopic(g group, t topic) []*peer {
 	result := []*peer{}
+	var best topic
 	for _, server := range msgr.peers {
 		if server.state == peerConnected {
-			if _, found := server.topics[t]; found {
-				result = append(result, server)
+			pattern, found := bestMatch(server.topics[g], t)
+			if !found {
+				continue
+			}
+			if len(result) > 0 && best.isMoreSpecificThan(pattern) {
+				continue
+			}
+			if len(result) > 0 && pattern.isMoreSpecificThan(best) {
+				result = result[:0]
 			}
+			best = pattern
+			result = append(result, server)
 		}
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i].peerId < result[j].peerId })
 	return result
 }
 
@@ -702,15 +1439,33 @@ func (mType messageType) String() string {
 		return "join"
 	case leaving:
 		return "leaving"
+	case left:
+		return "left"
 	case subscribe:
 		return "subscribe"
 	case unsubscribe:
 		return "unsubscribe"
+	case replyError:
+		return "replyError"
+	case replyRejected:
+		return "replyRejected"
+	case ping:
+		return "ping"
+	case pong:
+		return "pong"
 	default:
-		panic(fmt.Errorf("Unknown messageType %d", mType))
+		return fmt.Sprintf("messageType(%d)", mType)
 	}
 }
 
+func (peer *peer) Id() string {
+	return string(peer.peerId)
+}
+
+func (peer *peer) PendingReplies() int {
+	return len(peer.pendingReplies)
+}
+
 func (peer *peer) String() string {
 	return fmt.Sprintf("[peer: id: %s; topics: %d; state: %s]", peer.peerId, len(peer.topics), peer.state)
 }
@@ -730,6 +1485,13 @@ func (s peerState) String() string {
 	}
 }
 
+func (msg *message) body() []byte {
+	if msg == nil {
+		return nil
+	}
+	return msg.Body
+}
+
 func (msg *message) String() string {
 	if msg == nil {
 		return "[message: <nil>]"
@@ -752,9 +1514,23 @@ func resolveAddr(addr string) (hostId, error) {
 }
 
 func (msgr *messenger) newJoinMessage() *joinMessage {
-	joinMsg := &joinMessage{HostId: msgr.hostId}
-	for t := range msgr.subscriptions {
-		joinMsg.Topics = append(joinMsg.Topics, t)
+	joinMsg := &joinMessage{
+		HostId:       msgr.hostId,
+		Version:      protocolVersion,
+		MinVersion:   minProtocolVersion,
+		Capabilities: capabilities,
+		Codecs:       msgr.codecNames(),
+		Compressors:  msgr.compressorNames(),
+		MaxMessage:   msgr.MaxMessageSize,
+	}
+	for g, handlers := range msgr.subscriptions {
+		for t := range handlers {
+			if g == defaultGroup {
+				joinMsg.Topics = append(joinMsg.Topics, t)
+			} else {
+				joinMsg.Groups = append(joinMsg.Groups, subscribeMessageBody{Topic: t, Group: g})
+			}
+		}
 	}
 	for p := range msgr.peers {
 		joinMsg.Peers = append(joinMsg.Peers, p)
@@ -763,6 +1539,24 @@ func (msgr *messenger) newJoinMessage() *joinMessage {
 	return joinMsg
 }
 
+// writeJoinReply answers an accepted join, telling the dialer to use codec
+// and compressor, if not nil, from now on.
+func (msgr *messenger) writeJoinReply(conn net.Conn, codec Codec, compressor Compressor) error {
+	joinMsg := msgr.newJoinMessage()
+	joinMsg.Codec = codec.Name()
+	if compressor != nil {
+		joinMsg.Compressor = compressor.Name()
+	}
+	buf := &bytes.Buffer{}
+	encode(&ch, joinMsg, buf)
+	msg := &message{
+		MessageId:   newId(),
+		MessageType: join,
+		Body:        buf.Bytes(),
+	}
+	return writeMessage(conn, msg, defaultCodec)
+}
+
 func (msgr *messenger) logf(format string, params ...interface{}) {
-	Log.Debugf(">>> %s: "+format, append([]interface{}{string(msgr.hostId) + "-messenger"}, params...)...)
+	msgr.Log.Debugf(">>> %s: "+format, append([]interface{}{string(msgr.hostId) + "-messenger"}, params...)...)
 }
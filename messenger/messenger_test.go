@@ -44,9 +44,9 @@ func TestOneOnOne(t *testing.T) {
 func TestOneOnThree(t *testing.T) {
 	log.Println("---------------- TestOneOnThree ----------------")
 
-	Timeout = time.Duration(5 * time.Second)
+	opts := Options{Timeout: 5 * time.Second}
 
-	server1, err := NewMessenger("localhost:50001")
+	server1, err := NewMessengerWithOptions("localhost:50001", opts)
 	if err != nil {
 		t.FailNow()
 	}
@@ -54,7 +54,7 @@ func TestOneOnThree(t *testing.T) {
 	server1.Join()
 	server1.Subscribe("job", echo1)
 
-	server2, err := NewMessenger("localhost:50002")
+	server2, err := NewMessengerWithOptions("localhost:50002", opts)
 	if err != nil {
 		t.FailNow()
 	}
@@ -62,7 +62,7 @@ func TestOneOnThree(t *testing.T) {
 	server2.Subscribe("job", echo2)
 	server2.Join("localhost:50001")
 
-	server3, err := NewMessenger("localhost:50003")
+	server3, err := NewMessengerWithOptions("localhost:50003", opts)
 	if err != nil {
 		t.FailNow()
 	}
@@ -73,7 +73,7 @@ func TestOneOnThree(t *testing.T) {
 	server3.Subscribe("job", echo3)
 	log.Printf("%%%%%% 3")
 
-	client, err := NewMessenger("localhost:40000")
+	client, err := NewMessengerWithOptions("localhost:40000", opts)
 	if err != nil {
 		t.FailNow()
 	}
@@ -196,9 +196,9 @@ func TestTwoOnTwo(t *testing.T) {
 func TestDisconnect(t *testing.T) {
 	log.Println("---------------- TestDisconnect ----------------")
 
-	Timeout = 2 * time.Second
+	opts := Options{Timeout: 2 * time.Second}
 
-	server1, err := NewMessenger("localhost:50000")
+	server1, err := NewMessengerWithOptions("localhost:50000", opts)
 	if err != nil {
 		t.FailNow()
 	}
@@ -206,7 +206,7 @@ func TestDisconnect(t *testing.T) {
 	server1.Subscribe("job", echo1)
 	server1.Join()
 
-	server2, err := NewMessenger("localhost:50001")
+	server2, err := NewMessengerWithOptions("localhost:50001", opts)
 	if err != nil {
 		t.FailNow()
 	}
@@ -214,14 +214,14 @@ func TestDisconnect(t *testing.T) {
 	server2.Subscribe("job", echo2)
 	server2.Join("localhost:50000")
 
-	client1, err := NewMessenger("localhost:40000")
+	client1, err := NewMessengerWithOptions("localhost:40000", opts)
 	if err != nil {
 		t.FailNow()
 	}
 	defer client1.Leave()
 	client1.Join("localhost:50000")
 
-	client2, err := NewMessenger("localhost:40001")
+	client2, err := NewMessengerWithOptions("localhost:40001", opts)
 	if err != nil {
 		t.FailNow()
 	}
@@ -230,14 +230,14 @@ func TestDisconnect(t *testing.T) {
 
 	var c int64 = 0
 	var cc int64
-	readMessage = func(conn net.Conn) (*message, error) {
+	readMessage = func(conn net.Conn, wire Codec, maxSize int) (*message, error) {
 		if cc%20 == 0 {
 			cc = atomic.AddInt64(&c, 1)
 			log.Printf("### closing connection %s:%s [%d] ---", conn.RemoteAddr(), conn.LocalAddr(), cc)
 			conn.Close()
 			cc = atomic.AddInt64(&c, 1)
 		}
-		return _readMessage(conn)
+		return _readMessage(conn, wire, maxSize)
 	}
 
 	c1s1, c1s2, c2s1, c2s2 := 0, 0, 0, 0
@@ -351,3 +351,17 @@ func echo2(topic string, body []byte) []byte {
 func echo3(topic string, body []byte) []byte {
 	return []byte("server:3 " + string(body))
 }
+
+func TestAddressInUse(t *testing.T) {
+	log.Println("---------------- TestAddressInUse ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+
+	if _, err := NewMessenger("localhost:50000"); err == nil {
+		t.Errorf("Expected an error listening on an address in use")
+	}
+}
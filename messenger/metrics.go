@@ -0,0 +1,271 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives the messenger's measurements. Implementations must be safe
+// for concurrent use and must not modify labels.
+//
+// The messenger reports:
+//
+//	envoy_messages_sent_total{topic,kind}      counter: publish, request, broadcast, survey
+//	envoy_request_duration_seconds{topic,kind} histogram: request and survey round trips
+//	envoy_reply_duration_seconds{peer}         histogram: time until a peer's reply arrived
+//	envoy_send_errors_total{topic,kind}        counter: failed sends, timeouts included
+//	envoy_timeouts_total{topic}                counter
+//	envoy_messages_received_total{topic,kind}  counter: publish, request
+//	envoy_handler_duration_seconds{topic}      histogram
+//	envoy_handler_errors_total{topic}          counter
+//	envoy_handler_panics_total{topic}          counter
+//	envoy_rejected_total{topic}                counter: messages refused by a full ExecutionMode queue
+//	envoy_dropped_frames_total{peer,kind}      counter: replies and heartbeats dropped by a full write queue
+//	envoy_bytes_read_total{peer}               counter
+//	envoy_bytes_written_total{peer}            counter
+//	envoy_pending_replies{peer}                gauge
+//	envoy_redials_total{peer}                  counter
+//	envoy_peers                                gauge: connected peers
+type Metrics interface {
+	// Add adds delta to a counter.
+	Add(name string, labels Labels, delta float64)
+	// Set sets a gauge.
+	Set(name string, labels Labels, value float64)
+	// Observe records a value, in seconds for durations, into a histogram.
+	Observe(name string, labels Labels, value float64)
+	// Delete removes a series, such as the gauge of a peer that is gone.
+	Delete(name string, labels Labels)
+}
+
+type Labels map[string]string
+
+type discardMetrics struct{}
+
+func (discardMetrics) Add(string, Labels, float64)     {}
+func (discardMetrics) Set(string, Labels, float64)     {}
+func (discardMetrics) Observe(string, Labels, float64) {}
+func (discardMetrics) Delete(string, Labels)           {}
+
+// DefaultBuckets are the histogram bucket upper bounds used by NewRegistry, in seconds.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry is an in-memory Metrics sink. It is also an http.Handler
+// that renders its contents in the Prometheus text exposition format.
+type Registry struct {
+	buckets  []float64
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+type family struct {
+	kind   string
+	series map[string]*series
+}
+
+type series struct {
+	value  float64
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+}
+
+func NewRegistry() *Registry {
+	return NewRegistryWithBuckets(DefaultBuckets)
+}
+
+// NewRegistryWithBuckets is NewRegistry with histogram bucket upper bounds other than DefaultBuckets.
+func NewRegistryWithBuckets(buckets []float64) *Registry {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Registry{
+		buckets:  buckets,
+		families: make(map[string]*family),
+	}
+}
+
+func (r *Registry) Add(name string, labels Labels, delta float64) {
+	r.mutex.Lock()
+	r.series("counter", name, labels).value += delta
+	r.mutex.Unlock()
+}
+
+func (r *Registry) Set(name string, labels Labels, value float64) {
+	r.mutex.Lock()
+	r.series("gauge", name, labels).value = value
+	r.mutex.Unlock()
+}
+
+func (r *Registry) Observe(name string, labels Labels, value float64) {
+	r.mutex.Lock()
+	s := r.series("histogram", name, labels)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(r.buckets))
+	}
+	if i := sort.SearchFloat64s(r.buckets, value); i < len(r.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.value += value
+	r.mutex.Unlock()
+}
+
+func (r *Registry) Delete(name string, labels Labels) {
+	r.mutex.Lock()
+	if f := r.families[name]; f != nil {
+		delete(f.series, formatLabels(labels))
+	}
+	r.mutex.Unlock()
+}
+
+func (r *Registry) series(kind, name string, labels Labels) *series {
+	f := r.families[name]
+	if f == nil {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	key := formatLabels(labels)
+	s := f.series[key]
+	if s == nil {
+		s = &series{}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b := &strings.Builder{}
+	for _, name := range sortedKeys(r.families) {
+		f := r.families[name]
+		fmt.Fprintf(b, "# TYPE %s %s\n", name, f.kind)
+		for _, key := range sortedKeys(f.series) {
+			s := f.series[key]
+			if f.kind != "histogram" {
+				fmt.Fprintf(b, "%s%s %s\n", name, key, formatFloat(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, le := range r.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(b, "%s_bucket%s %d\n", name, withLabel(key, "le", formatFloat(le)), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", name, withLabel(key, "le", "+Inf"), s.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", name, key, formatFloat(s.value))
+			fmt.Fprintf(b, "%s_count%s %d\n", name, key, s.count)
+		}
+	}
+	io.WriteString(w, b.String())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders labels as {a="1",b="2"}, sorted by name, or "" if there are none.
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, name := range sortedKeys(labels) {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(labels[name])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(formatted, name, value string) string {
+	pair := fmt.Sprintf(`%s="%s"`, name, value)
+	if formatted == "" {
+		return "{" + pair + "}"
+	}
+	return formatted[:len(formatted)-1] + "," + pair + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// meteredConn counts the bytes read from and written to a peer.
+type meteredConn struct {
+	net.Conn
+	metrics Metrics
+	labels  Labels
+}
+
+func (conn meteredConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	if n > 0 {
+		conn.metrics.Add("envoy_bytes_read_total", conn.labels, float64(n))
+	}
+	return n, err
+}
+
+func (conn meteredConn) Write(b []byte) (int, error) {
+	n, err := conn.Conn.Write(b)
+	if n > 0 {
+		conn.metrics.Add("envoy_bytes_written_total", conn.labels, float64(n))
+	}
+	return n, err
+}
+
+// reportSent records a send of the given kind that started at start and ended with err.
+func (msgr *messenger) reportSent(msg *message, kind string, start time.Time, err error) {
+	labels := Labels{"topic": string(msg.Topic), "kind": kind}
+	msgr.Metrics.Add("envoy_messages_sent_total", labels, 1)
+	if msg.MessageType == request {
+		msgr.Metrics.Observe("envoy_request_duration_seconds", labels, time.Since(start).Seconds())
+	}
+	if err != nil {
+		msgr.Metrics.Add("envoy_send_errors_total", labels, 1)
+		if errors.Is(err, TimeoutError) {
+			msgr.Metrics.Add("envoy_timeouts_total", topicLabels(msg.Topic), 1)
+		}
+	}
+}
+
+func topicLabels(t topic) Labels {
+	return Labels{"topic": string(t)}
+}
+
+func peerLabels(peerId hostId) Labels {
+	return Labels{"peer": string(peerId)}
+}
+
+func (peer *peer) reportPendingReplies() {
+	if _, discard := peer.msgr.Metrics.(discardMetrics); discard {
+		return
+	}
+	peer.msgr.Metrics.Set("envoy_pending_replies", peerLabels(peer.peerId), float64(len(peer.pendingReplies)))
+}
+
+func (msgr *messenger) reportPeers() {
+	connected := 0
+	for _, peer := range msgr.peers {
+		if peer.state == peerConnected {
+			connected++
+		}
+	}
+	msgr.Metrics.Set("envoy_peers", nil, float64(connected))
+}
@@ -0,0 +1,108 @@
+package messenger
+
+import (
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryText(t *testing.T) {
+	r := NewRegistryWithBuckets([]float64{0.1, 1})
+	r.Add("requests_total", Labels{"topic": "job"}, 1)
+	r.Add("requests_total", Labels{"topic": "job"}, 2)
+	r.Set("peers", nil, 3)
+	r.Observe("duration_seconds", Labels{"topic": `a"b`}, 0.5)
+	r.Observe("duration_seconds", Labels{"topic": `a"b`}, 2)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `# TYPE duration_seconds histogram
+duration_seconds_bucket{topic="a\"b",le="0.1"} 0
+duration_seconds_bucket{topic="a\"b",le="1"} 1
+duration_seconds_bucket{topic="a\"b",le="+Inf"} 2
+duration_seconds_sum{topic="a\"b"} 2.5
+duration_seconds_count{topic="a\"b"} 2
+# TYPE peers gauge
+peers 3
+# TYPE requests_total counter
+requests_total{topic="job"} 3
+`
+	if w.Body.String() != expected {
+		t.Fatalf("Expected:\n%s\nreceived:\n%s", expected, w.Body.String())
+	}
+}
+
+func TestRegistryDelete(t *testing.T) {
+	r := NewRegistry()
+	r.Set("pending", Labels{"peer": "a"}, 1)
+	r.Set("pending", Labels{"peer": "b"}, 2)
+	r.Delete("pending", Labels{"peer": "a"})
+	r.Delete("unknown", nil)
+
+	text := &strings.Builder{}
+	r.WriteText(text)
+	if expected := "# TYPE pending gauge\npending{peer=\"b\"} 2\n"; text.String() != expected {
+		t.Fatalf("Expected:\n%s\nreceived:\n%s", expected, text)
+	}
+}
+
+func TestMessengerMetrics(t *testing.T) {
+	log.Println("---------------- TestMessengerMetrics ----------------")
+
+	serverMetrics := NewRegistry()
+	server, err := NewMessengerWithOptions("localhost:50000", Options{Metrics: serverMetrics})
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	clientMetrics := NewRegistry()
+	client, err := NewMessengerWithOptions("localhost:40000", Options{Metrics: clientMetrics})
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.Request("job", []byte("Hello")); err != nil {
+			t.Fatalf("Request returned error: %v", err)
+		}
+	}
+
+	if _, _, err := client.Survey("job", []byte("Hello")); err != nil {
+		t.Fatalf("Survey returned error: %v", err)
+	}
+
+	text := &strings.Builder{}
+	clientMetrics.WriteText(text)
+	for _, line := range []string{
+		`envoy_messages_sent_total{kind="request",topic="job"} 3`,
+		`envoy_request_duration_seconds_count{kind="request",topic="job"} 3`,
+		`envoy_request_duration_seconds_count{kind="survey",topic="job"} 1`,
+		`envoy_reply_duration_seconds_count{peer="127.0.0.1:50000"} 4`,
+		`envoy_pending_replies{peer="127.0.0.1:50000"} 0`,
+		`envoy_peers 1`,
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("Client metrics lack %q:\n%s", line, text)
+		}
+	}
+
+	text.Reset()
+	serverMetrics.WriteText(text)
+	for _, line := range []string{
+		`envoy_messages_received_total{kind="request",topic="job"} 4`,
+		`envoy_handler_duration_seconds_count{topic="job"} 4`,
+		`envoy_bytes_read_total{peer="127.0.0.1:40000"}`,
+		`envoy_bytes_written_total{peer="127.0.0.1:40000"}`,
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("Server metrics lack %q:\n%s", line, text)
+		}
+	}
+}
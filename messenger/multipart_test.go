@@ -0,0 +1,204 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/andrew-suprun/envoy/actor"
+	"github.com/andrew-suprun/envoy/future"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMultiPart(t *testing.T) {
+	log.Println("---------------- TestMultiPart ----------------")
+
+	opts := Options{PartSize: 1024}
+	published := make(chan []byte, 1)
+
+	server, err := NewMessengerWithOptions("localhost:50000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Subscribe("store", func(topic string, body []byte) []byte {
+		published <- body
+		return nil
+	})
+	server.Join()
+
+	client, err := NewMessengerWithOptions("localhost:40000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	large := make([]byte, 1024*1024+17)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			reply, _, err := client.Request("job", []byte("Hello"))
+			if err != nil || string(reply) != "Hello" {
+				t.Errorf("Expected: 'Hello'; received '%s'; err = %v", string(reply), err)
+				return
+			}
+		}
+	}()
+
+	reply, _, err := client.Request("job", large)
+	if err != nil {
+		t.Fatalf("Request returned error: %s", err)
+	}
+	if !bytes.Equal(reply, large) {
+		t.Fatalf("Large reply differs from request: len = %d", len(reply))
+	}
+	wg.Wait()
+
+	if _, err := client.Publish("store", large); err != nil {
+		t.Fatalf("Publish returned error: %s", err)
+	}
+	if body := <-published; !bytes.Equal(body, large) {
+		t.Fatalf("Large published body differs: len = %d", len(body))
+	}
+}
+
+func TestWriterInterleavesParts(t *testing.T) {
+	opts := Options{PartSize: 16, WriteQueueSize: 8}
+	results := actor.NewTypedActor[msgrEvent]("results", func(msgrEvent) {}).Start()
+	defer results.Stop()
+	writerConn, readerConn := net.Pipe()
+	defer readerConn.Close()
+	w := newWriter("writer", "peer", writerConn, defaultCodec, nil, results, true, nil, &opts)
+	defer w.Stop()
+
+	large := &message{MessageId: newId(), MessageType: publish, Topic: "large", Body: make([]byte, 16*100)}
+	small := &message{MessageId: newId(), MessageType: publish, Topic: "small", Body: []byte("Hello")}
+	w.Send(writeRequest{msg: large})
+	w.Send(writeRequest{msg: small})
+
+	for parts := 0; ; {
+		msg, err := readMessage(readerConn, defaultCodec, defaultMaxFrameSize)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if msg.MessageId == small.MessageId {
+			if parts > 2 {
+				t.Errorf("Small message written after %d parts of the large one", parts)
+			}
+			return
+		}
+		if parts++; !msg.More {
+			t.Fatalf("Small message not written before the large one was complete")
+		}
+	}
+}
+
+func TestWriterLimitsPartialMessages(t *testing.T) {
+	opts := Options{PartSize: 16, WriteQueueSize: 64}
+	results := actor.NewTypedActor[msgrEvent]("results", func(msgrEvent) {}).Start()
+	defer results.Stop()
+	writerConn, readerConn := net.Pipe()
+	defer readerConn.Close()
+	w := newWriter("writer", "peer", writerConn, defaultCodec, nil, results, true, nil, &opts)
+	defer w.Stop()
+
+	const count = maxPartialMessages + 4
+	for i := 0; i < count; i++ {
+		w.Send(writeRequest{msg: &message{MessageId: newId(), MessageType: publish, Topic: "large", Body: make([]byte, 16*4)}})
+	}
+
+	r := &reader{hostId: "peer", parts: make(map[messageId]*message), Options: &Options{MaxMessageSize: defaultMaxMessageSize}}
+	for complete := 0; complete < count; {
+		part, err := readMessage(readerConn, defaultCodec, defaultMaxFrameSize)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		msg, err := r.assemble(part)
+		if err != nil {
+			t.Fatalf("Reader refused the writer's parts: %v", err)
+		}
+		if msg != nil {
+			complete++
+		}
+	}
+}
+
+func TestFullWriteQueue(t *testing.T) {
+	events := make(chan msgrEvent, 1)
+	msgr := &messenger{
+		TypedActor: actor.NewTypedActor[msgrEvent]("messenger", func(event msgrEvent) { events <- event }).Start(),
+		Options:    Options{Metrics: discardMetrics{}, Log: Log},
+	}
+	defer msgr.Stop()
+	// The writer is never started, so its queue stays full after one frame.
+	peer := msgr.newPeer("peer")
+	peer.writer = actor.NewTypedActor[writeRequest]("writer", func(writeRequest) {}, actor.Mailbox(1, actor.Block))
+	peer.write(&message{MessageId: newId(), MessageType: publish})
+
+	req := &message{MessageId: newId(), MessageType: request}
+	result := future.NewTyped[*message]()
+	peer.expectReply(req.MessageId, setReply(result))
+	peer.write(req)
+	if _, err := result.Wait(context.Background()); !errors.Is(err, actor.MailboxFullError) {
+		t.Errorf("Expected MailboxFullError; received %v", err)
+	}
+
+	// Replies and heartbeats are dropped; the next frame tears the peer down.
+	peer.write(&message{MessageId: newId(), MessageType: reply})
+	peer.write(&message{MessageId: newId(), MessageType: replyRejected})
+	peer.write(&message{MessageId: newId(), MessageType: pong})
+	peer.write(&message{MessageType: subscribe})
+	if event, ok := (<-events).(networkErrorEvent); !ok || !errors.Is(event.err, actor.MailboxFullError) {
+		t.Errorf("Expected a network error for the peer; received %#v", event)
+	}
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	log.Println("---------------- TestMessageSizeLimits ----------------")
+
+	server, err := NewMessengerWithOptions("localhost:50000", Options{PartSize: 1024, MaxMessageSize: 64 * 1024})
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Subscribe("large", func(topic string, body []byte) []byte {
+		return make([]byte, 128*1024)
+	})
+	server.Join()
+
+	client, err := NewMessengerWithOptions("localhost:40000", Options{PartSize: 1024, MaxMessageSize: 96 * 1024})
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	// Above the server's limit only, then above the client's own.
+	for _, size := range []int{80 * 1024, 128 * 1024} {
+		if _, _, err := client.Request("job", make([]byte, size)); !errors.Is(err, MessageTooLargeError) {
+			t.Errorf("Expected MessageTooLargeError for %d bytes; received %v", size, err)
+		}
+		if _, err := client.Broadcast("job", make([]byte, size)); !errors.Is(err, MessageTooLargeError) {
+			t.Errorf("Expected MessageTooLargeError broadcasting %d bytes; received %v", size, err)
+		}
+	}
+	remoteErr := &RemoteError{}
+	if _, _, err := client.Request("large", []byte("Hello")); !errors.As(err, &remoteErr) || !strings.Contains(remoteErr.Message, MessageTooLargeError.Error()) {
+		t.Errorf("Expected a RemoteError for a reply above the client's limit; received %v", err)
+	}
+	if reply, _, err := client.Request("job", []byte("Hello")); err != nil || string(reply) != "Hello" {
+		t.Fatalf("Peer was disconnected: received '%s'; err = %v", reply, err)
+	}
+}
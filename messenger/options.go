@@ -0,0 +1,166 @@
+package messenger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+)
+
+const (
+	defaultPartSize       = 64 * 1024
+	defaultWriteQueueSize = 1024
+	defaultMaxFrameSize   = 16 * 1024 * 1024
+	defaultMaxMessageSize = 64 * 1024 * 1024
+	defaultHeartbeat      = 5 * time.Second
+	defaultWriteTimeout   = 10 * time.Second
+)
+
+// Options configures a single messenger instance.
+// Zero-valued fields are filled in from the package-level defaults
+// (Timeout, RedialInterval, Log and a CBOR codec).
+type Options struct {
+	// Timeout bounds every Publish, Request, Broadcast and Survey call as well as Leave
+	// and the exchange of join messages with a newly connected peer.
+	Timeout time.Duration
+
+	// RedialInterval is the delay before re-dialing a peer that failed or disconnected.
+	RedialInterval time.Duration
+
+	Log Logger
+
+	// HeartbeatInterval is how often peers are pinged. Defaults to 5s;
+	// a negative value disables heartbeats and with them IdleTimeout.
+	HeartbeatInterval time.Duration
+
+	// IdleTimeout is how long a connection to a peer that answers pings may
+	// stay silent before the peer is considered dead, disconnected and re-dialed.
+	// Defaults to three heartbeat intervals.
+	IdleTimeout time.Duration
+
+	// WriteTimeout bounds writing a single frame to a peer.
+	// Defaults to 10s; a negative value disables it.
+	WriteTimeout time.Duration
+
+	// Codec is the wire codec this messenger prefers. It is offered first when
+	// dialing; connections fall back to another codec both sides support.
+	// Defaults to NewCBORCodec().
+	Codec Codec
+
+	// Compressors are offered to peers in order of preference. Bodies of at
+	// least CompressionThreshold bytes are compressed on connections where
+	// both ends share one. Defaults to none, e.g. use NewGzipCompressor.
+	Compressors []Compressor
+
+	// CompressionThreshold is the smallest body worth compressing. Defaults to 1KB.
+	CompressionThreshold int
+
+	// ListenAddress is the address the listener binds to.
+	// Defaults to the local address passed to NewMessengerWithOptions.
+	ListenAddress string
+
+	// PartSize is the largest body sent in a single frame. Larger bodies are
+	// split into parts that are interleaved with other traffic on the connection
+	// and reassembled by the receiver. Parts do not stream: the sender holds on
+	// to the whole body until its last part is written, and the receiver
+	// buffers the parts in memory, up to MaxMessageSize per message, until the
+	// body is complete. Defaults to 64KB.
+	PartSize int
+
+	// MaxFrameSize is the largest frame accepted from a peer; a larger length
+	// prefix is a protocol error that disconnects the peer before anything is
+	// allocated. Keep it well above PartSize, and above the largest body sent
+	// by peers that cannot split bodies into parts. Defaults to 16MB.
+	MaxFrameSize int
+
+	// MaxMessageSize is the largest body accepted from a peer once its parts
+	// are reassembled; a larger one is a protocol error that disconnects the
+	// peer. Messengers advertise it when joining, and sends of larger bodies,
+	// to this node or to any peer, fail with MessageTooLargeError before
+	// anything is written. Raise it on every node that sends or receives larger
+	// bodies. Defaults to 64MB.
+	MaxMessageSize int
+
+	// WriteQueueSize bounds the number of frames waiting to be written to a
+	// single peer. When the queue is full, a Publish or Request routed to the
+	// peer fails with actor.MailboxFullError, handlers replying to the peer
+	// wait for room, and any other frame fails the peer, which is re-dialed.
+	// Defaults to 1024.
+	WriteQueueSize int
+
+	// Balancer picks among the servers subscribed to a topic. Defaults to NewRandomBalancer().
+	Balancer Balancer
+
+	// TopicBalancers overrides Balancer for topics matching the keys,
+	// which may contain wildcards; the most specific match wins.
+	TopicBalancers map[string]Balancer
+
+	// TLSConfig, when set, secures both dialed and accepted peer connections.
+	// It must carry a certificate usable for both roles. Set ClientAuth to
+	// tls.RequireAndVerifyClientCert (with ClientCAs) for mutual TLS.
+	TLSConfig *tls.Config
+
+	// Metrics receives counters, gauges and histograms; see Metrics for the list.
+	// Use a Registry to expose them to Prometheus. Defaults to discarding them.
+	Metrics Metrics
+
+	// Tracer starts spans around sends and handlers. Without one, trace ids
+	// are still propagated but no spans are recorded.
+	Tracer Tracer
+
+	// VerifyPeer, when set, is called with the host id a peer claims in its
+	// join message and the certificates it presented (nil without TLS).
+	// A non-nil error rejects the peer.
+	VerifyPeer func(peerId string, certs []*x509.Certificate) error
+}
+
+func (opts Options) withDefaults(local hostId) Options {
+	if opts.Timeout == 0 {
+		opts.Timeout = Timeout
+	}
+	if opts.RedialInterval == 0 {
+		opts.RedialInterval = RedialInterval
+	}
+	if opts.HeartbeatInterval == 0 {
+		opts.HeartbeatInterval = defaultHeartbeat
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 3 * opts.HeartbeatInterval
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = defaultWriteTimeout
+	}
+	if opts.Log == nil {
+		opts.Log = Log
+	}
+	if opts.Codec == nil {
+		opts.Codec = defaultCodec
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = discardMetrics{}
+	}
+	if opts.Tracer == nil {
+		opts.Tracer = propagatingTracer{}
+	}
+	if opts.Balancer == nil {
+		opts.Balancer = NewRandomBalancer()
+	}
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultPartSize
+	}
+	if opts.MaxFrameSize <= 0 {
+		opts.MaxFrameSize = defaultMaxFrameSize
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = defaultMaxMessageSize
+	}
+	if opts.CompressionThreshold <= 0 {
+		opts.CompressionThreshold = defaultCompressionThreshold
+	}
+	if opts.WriteQueueSize <= 0 {
+		opts.WriteQueueSize = defaultWriteQueueSize
+	}
+	if opts.ListenAddress == "" {
+		opts.ListenAddress = string(local)
+	}
+	return opts
+}
@@ -0,0 +1,19 @@
+package messenger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOptionsDefaults(t *testing.T) {
+	opts := Options{Timeout: time.Second}.withDefaults("127.0.0.1:50000")
+	if opts.Timeout != time.Second {
+		t.Fatalf("Explicit Timeout was overridden: %s", opts.Timeout)
+	}
+	if opts.RedialInterval != RedialInterval || opts.Log != Log || opts.Codec != defaultCodec {
+		t.Fatalf("Defaults were not applied: %+v", opts)
+	}
+	if opts.ListenAddress != "127.0.0.1:50000" {
+		t.Fatalf("Expected ListenAddress '127.0.0.1:50000'; received '%s'", opts.ListenAddress)
+	}
+}
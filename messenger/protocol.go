@@ -0,0 +1,90 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Protocol versions this node speaks. Peers that joined before versions were
+// exchanged send none and are treated as version 1 without capabilities.
+const (
+	protocolVersion    = 2
+	minProtocolVersion = 1
+)
+
+var IncompatibleProtocolError = errors.New("incompatible protocol version")
+
+// Capabilities are optional protocol features. Each one is used on a
+// connection only when both ends advertise it in their join messages.
+const (
+	capHeaders     = "headers"      // message and reply headers
+	capTrace       = "trace"        // traceparent of the sender's span
+	capParts       = "parts"        // bodies split into parts of Options.PartSize
+	capReplyErrors = "reply-errors" // replyError and replyRejected replies
+	capHeartbeat   = "heartbeat"    // ping and pong messages
+	capGroups      = "groups"       // subscriptions of named queue groups
+)
+
+var capabilities = []string{capHeaders, capTrace, capParts, capReplyErrors, capHeartbeat, capGroups}
+
+// checkProtocol returns an IncompatibleProtocolError unless the version
+// ranges of this node and of the peer that sent joinMsg overlap.
+func checkProtocol(joinMsg *joinMessage) error {
+	version, minVersion := joinMsg.Version, joinMsg.MinVersion
+	if version == 0 {
+		version = 1
+	}
+	if minVersion == 0 {
+		minVersion = version
+	}
+	if version < minProtocolVersion || minVersion > protocolVersion {
+		return fmt.Errorf("%w: peer %s speaks versions %d to %d, this node %d to %d",
+			IncompatibleProtocolError, joinMsg.HostId, minVersion, version, minProtocolVersion, protocolVersion)
+	}
+	return nil
+}
+
+// setCapabilities records the capabilities shared with the peer.
+func (peer *peer) setCapabilities(advertised []string) *peer {
+	peer.capabilities = make(map[string]bool)
+	for _, c := range advertised {
+		for _, own := range capabilities {
+			if c == own {
+				peer.capabilities[c] = true
+			}
+		}
+	}
+	return peer
+}
+
+func (peer *peer) supports(capability string) bool {
+	return peer.capabilities[capability]
+}
+
+// adapt strips from msg what the peer does not support. It returns nil for
+// messages the peer must not receive at all: subscriptions of named groups,
+// which peers without groups would take for the default group's.
+func (peer *peer) adapt(msg *message) *message {
+	if (msg.MessageType == subscribe || msg.MessageType == unsubscribe) && len(msg.Groups) > 0 && !peer.supports(capGroups) {
+		return nil
+	}
+	errorReply := msg.MessageType == replyError || msg.MessageType == replyRejected
+	if (msg.Headers == nil || peer.supports(capHeaders)) &&
+		(msg.TraceParent == "" || peer.supports(capTrace)) &&
+		(!errorReply || peer.supports(capReplyErrors)) {
+		return msg
+	}
+	adapted := *msg
+	if !peer.supports(capHeaders) {
+		adapted.Headers = nil
+	}
+	if !peer.supports(capTrace) {
+		adapted.TraceParent = ""
+	}
+	if errorReply && !peer.supports(capReplyErrors) {
+		// The closest reply older peers understand.
+		adapted.MessageType = replyPanic
+		adapted.Body = nil
+	}
+	return &adapted
+}
@@ -0,0 +1,102 @@
+package messenger
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"testing"
+)
+
+func TestCheckProtocol(t *testing.T) {
+	for _, test := range []struct {
+		joinMsg    joinMessage
+		compatible bool
+	}{
+		{joinMessage{}, true},
+		{joinMessage{Version: protocolVersion, MinVersion: minProtocolVersion}, true},
+		{joinMessage{Version: protocolVersion + 5, MinVersion: protocolVersion}, true},
+		{joinMessage{Version: protocolVersion + 5, MinVersion: protocolVersion + 1}, false},
+	} {
+		err := checkProtocol(&test.joinMsg)
+		if test.compatible != (err == nil) || (err != nil && !errors.Is(err, IncompatibleProtocolError)) {
+			t.Errorf("Versions %d to %d: unexpected result %v", test.joinMsg.MinVersion, test.joinMsg.Version, err)
+		}
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	legacy := (&peer{}).setCapabilities(nil)
+	msg := &message{MessageType: replyError, Headers: Headers{"a": "b"}, TraceParent: NewSpanContext(SpanContext{}).String(), Body: []byte("error")}
+	adapted := legacy.adapt(msg)
+	if adapted.Headers != nil || adapted.TraceParent != "" || adapted.MessageType != replyPanic || adapted.Body != nil {
+		t.Errorf("Message not adapted to legacy peer: %+v", adapted)
+	}
+	if msg.Headers == nil || msg.MessageType != replyError {
+		t.Errorf("adapt modified the original message: %+v", msg)
+	}
+
+	if adapted := legacy.adapt(newSubscribeMessage(subscribe, "job", "billing")); adapted != nil {
+		t.Errorf("Named group subscription sent to a peer without groups: %+v", adapted)
+	}
+	if sub := newSubscribeMessage(subscribe, "job", defaultGroup); legacy.adapt(sub) != sub {
+		t.Errorf("Default group subscription adapted for a peer without groups")
+	}
+
+	current := (&peer{}).setCapabilities(append(capabilities, "teleport"))
+	if current.adapt(msg) != msg {
+		t.Errorf("Message adapted for a peer supporting everything")
+	}
+	if current.supports("teleport") {
+		t.Errorf("Peer supports a capability this node lacks")
+	}
+}
+
+func rawJoin(t *testing.T, addr string, joinMsg *joinMessage) (net.Conn, *joinMessage) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	encode(&ch, joinMsg, buf)
+	if err := writeMessage(conn, &message{MessageId: newId(), MessageType: join, Body: buf.Bytes()}, defaultCodec); err != nil {
+		t.Fatalf("Failed to write join: %v", err)
+	}
+	replyMsg, err := readMessage(conn, defaultCodec, defaultMaxFrameSize)
+	if err != nil {
+		t.Fatalf("Failed to read join reply: %v", err)
+	}
+	reply := &joinMessage{}
+	decode(&ch, bytes.NewBuffer(replyMsg.Body), reply)
+	return conn, reply
+}
+
+func TestProtocolNegotiation(t *testing.T) {
+	log.Println("---------------- TestProtocolNegotiation ----------------")
+
+	server, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	conn, joinReply := rawJoin(t, "localhost:50000", &joinMessage{HostId: "127.0.0.1:1", Version: 9, MinVersion: 9})
+	if joinReply.Version != protocolVersion || joinReply.MinVersion != minProtocolVersion {
+		t.Errorf("Expected versions %d to %d; received %d to %d", minProtocolVersion, protocolVersion, joinReply.MinVersion, joinReply.Version)
+	}
+	if _, err := readMessage(conn, defaultCodec, defaultMaxFrameSize); err == nil {
+		t.Errorf("Incompatible peer was not disconnected")
+	}
+	conn.Close()
+
+	conn, _ = rawJoin(t, "localhost:50000", &joinMessage{HostId: "127.0.0.1:1"})
+	defer conn.Close()
+	writeMessage(conn, &message{MessageId: newId(), MessageType: messageType(99)}, defaultCodec)
+	writeMessage(conn, &message{MessageId: newId(), MessageType: request, Topic: "job", Body: []byte("Hello")}, defaultCodec)
+	replyMsg, err := readMessage(conn, defaultCodec, defaultMaxFrameSize)
+	if err != nil || replyMsg.MessageType != reply || string(replyMsg.Body) != "Hello" {
+		t.Fatalf("Expected reply 'Hello' after an unknown message type; received %+v; err = %v", replyMsg, err)
+	}
+}
@@ -1,44 +1,114 @@
 package messenger
 
 import (
+	"fmt"
 	"github.com/andrew-suprun/envoy/actor"
 	"net"
+	"time"
 )
 
+// maxPartialMessages is the number of multi-part messages a connection carries
+// at once. Writers interleave no more; readers disconnect peers that send more.
+const maxPartialMessages = 16
+
 type reader struct {
 	name string
 	hostId
-	actor.Actor
+	actor.TypedActor[struct{}]
 	net.Conn
-	recipient actor.Actor
+	codec       Codec
+	compressor  Compressor
+	idleTimeout time.Duration // read deadline; none if zero
+	recipient   actor.TypedActor[msgrEvent]
+	parts       map[messageId]*message
+	*Options
 }
 
-func newReader(name string, hostId hostId, conn net.Conn, recipient actor.Actor) actor.Actor {
+func newReader(name string, hostId hostId, conn net.Conn, codec Codec, compressor Compressor, idleTimeout time.Duration, recipient actor.TypedActor[msgrEvent], supervisor actor.Supervisor, opts *Options) actor.TypedActor[struct{}] {
 	reader := &reader{
-		name:      name,
-		hostId:    hostId,
-		Actor:     actor.NewActor(name),
-		Conn:      conn,
-		recipient: recipient,
+		name:        name,
+		hostId:      hostId,
+		Conn:        conn,
+		codec:       codec,
+		compressor:  compressor,
+		idleTimeout: idleTimeout,
+		recipient:   recipient,
+		parts:       make(map[messageId]*message),
+		Options:     opts,
 	}
 
-	reader.
-		RegisterHandler("read-message", reader.handleReadMessage).
-		Start().
-		Send("read-message")
+	reader.TypedActor = actor.NewTypedActor(name, reader.handleReadMessage, actor.Supervise(supervisor)).Start()
+	reader.Send(struct{}{})
 	return reader
 }
 
-func (reader *reader) handleReadMessage(_ string, _ []interface{}) {
-	msg, err := readMessage(reader.Conn)
+func (reader *reader) handleReadMessage(struct{}) {
+	if reader.idleTimeout > 0 {
+		reader.Conn.SetReadDeadline(time.Now().Add(reader.idleTimeout))
+	}
+	msg, err := readMessage(reader.Conn, reader.codec, reader.MaxFrameSize)
+	if err == nil {
+		err = reader.decompress(msg)
+	}
+	if err == nil {
+		msg, err = reader.assemble(msg)
+	}
 	if err != nil {
-		reader.recipient.Send("network-error", reader.hostId, err)
+		reader.recipient.Send(networkErrorEvent{peerId: reader.hostId, err: err})
 	} else {
-		reader.recipient.Send("message", reader.hostId, msg)
-		reader.Send("read-message")
+		if msg != nil {
+			reader.recipient.Send(messageEvent{from: reader.hostId, msg: msg})
+		}
+		reader.Send(struct{}{})
+	}
+}
+
+func (reader *reader) decompress(msg *message) error {
+	if !msg.Compressed {
+		return nil
+	}
+	if reader.compressor == nil {
+		return UnsupportedCompressionError
+	}
+	body, err := reader.compressor.Decompress(msg.Body, reader.MaxFrameSize)
+	if err != nil {
+		return err
+	}
+	msg.Body, msg.Compressed = body, false
+	return nil
+}
+
+// assemble collects the parts of a multi-part message.
+// It returns the complete message once its last part arrives, and nil before that.
+// Bodies above MaxMessageSize and more than maxPartialMessages at once are
+// protocol errors.
+func (reader *reader) assemble(part *message) (*message, error) {
+	msg, found := reader.parts[part.MessageId]
+	if !found {
+		if len(part.Body) > reader.MaxMessageSize {
+			return nil, fmt.Errorf("%w: message of %d bytes from %s, limit %d", FrameTooLargeError, len(part.Body), reader.hostId, reader.MaxMessageSize)
+		}
+		if !part.More {
+			return part, nil
+		}
+		if len(reader.parts) >= maxPartialMessages {
+			return nil, fmt.Errorf("%w: more than %d messages in parts at once from %s", MalformedFrameError, maxPartialMessages, reader.hostId)
+		}
+		reader.parts[part.MessageId] = part
+		return nil, nil
+	}
+	if len(msg.Body)+len(part.Body) > reader.MaxMessageSize {
+		return nil, fmt.Errorf("%w: message of more than %d bytes from %s", FrameTooLargeError, reader.MaxMessageSize, reader.hostId)
+	}
+	msg.Body = append(msg.Body, part.Body...)
+	if part.More {
+		return nil, nil
 	}
+	delete(reader.parts, part.MessageId)
+	msg.More = false
+	return msg, nil
 }
 
 func (reader *reader) logf(format string, params ...interface{}) {
-	Log.Debugf(">>> %s: "+format, append([]interface{}{reader.name}, params...)...)
+	reader.Log.Debugf(">>> %s: "+format, append([]interface{}{reader.name}, params...)...)
 }
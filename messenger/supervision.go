@@ -0,0 +1,43 @@
+package messenger
+
+import "github.com/andrew-suprun/envoy/actor"
+
+// peerSupervisor stops a failed reader or writer and tears its peer down
+// as if the connection had failed, so the peer is re-dialed.
+func (msgr *messenger) peerSupervisor(peerId hostId) actor.Supervisor {
+	return func(failure *actor.Failure) actor.Strategy {
+		msgr.logFailure(failure)
+		msgr.Send(networkErrorEvent{peerId: peerId, err: failure})
+		return actor.Stop
+	}
+}
+
+// dialerSupervisor fails the dial that panicked; the dialer keeps serving other dials.
+func (msgr *messenger) dialerSupervisor(failure *actor.Failure) actor.Strategy {
+	msgr.logFailure(failure)
+	if req, ok := failure.Message.(dialRequest); ok {
+		msgr.Send(dialErrorEvent{peerId: req.peerId})
+	}
+	return actor.Resume
+}
+
+// listenerSupervisor keeps the listener accepting. Handshakes run outside
+// of the listener actor and recover from their own panics.
+func (msgr *messenger) listenerSupervisor(failure *actor.Failure) actor.Strategy {
+	msgr.logFailure(failure)
+	return actor.Resume
+}
+
+// messengerSupervisor keeps the messenger running. A panic while handling
+// a peer's message tears down only that peer.
+func (msgr *messenger) messengerSupervisor(failure *actor.Failure) actor.Strategy {
+	msgr.logFailure(failure)
+	if event, ok := failure.Message.(messageEvent); ok {
+		msgr.Send(networkErrorEvent{peerId: event.from, err: failure})
+	}
+	return actor.Resume
+}
+
+func (msgr *messenger) logFailure(failure *actor.Failure) {
+	msgr.Log.Errorf("%v\n%s", failure, failure.Stack)
+}
@@ -0,0 +1,51 @@
+package messenger
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReaderPanic(t *testing.T) {
+	log.Println("---------------- TestReaderPanic ----------------")
+
+	readMessage = func(conn net.Conn, c Codec, maxSize int) (*message, error) {
+		msg, err := _readMessage(conn, c, maxSize)
+		if err == nil && string(msg.Body) == "boom" {
+			panic("boom")
+		}
+		return msg, err
+	}
+	defer func() { readMessage = _readMessage }()
+
+	opts := Options{RedialInterval: 10 * time.Millisecond}
+	server, err := NewMessengerWithOptions("localhost:50000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	client, err := NewMessengerWithOptions("localhost:40000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	client.Publish("job", []byte("boom"))
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		reply, _, err := client.Request("job", []byte("Hello"))
+		if err == nil && string(reply) == "Hello" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Peer did not recover from reader panic: reply = '%s'; err = %v", string(reply), err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
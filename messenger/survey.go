@@ -0,0 +1,98 @@
+package messenger
+
+import "sync"
+
+// survey collects the outcome of a broadcast from every node it went to.
+// Peers complete it on the messenger, the local node from a handler goroutine
+// and an expired context from its own; done is called once, by whichever
+// completes it last.
+type survey struct {
+	mutex    sync.Mutex
+	waiting  map[hostId]struct{}
+	sealed   bool // all nodes are expected
+	err      error
+	bodies   [][]byte
+	failures map[string]error
+	done     func(bodies [][]byte, err error)
+}
+
+func newSurvey(done func([][]byte, error)) *survey {
+	return &survey{
+		waiting:  make(map[hostId]struct{}),
+		failures: make(map[string]error),
+		done:     done,
+	}
+}
+
+// expect adds nodeId to the nodes the survey waits for and returns the
+// done func for its reply.
+func (s *survey) expect(nodeId hostId) func(*message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.done != nil {
+		s.waiting[nodeId] = struct{}{}
+	}
+	return func(reply *message, err error) {
+		s.complete(nodeId, reply, err)
+	}
+}
+
+func (s *survey) complete(nodeId hostId, reply *message, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, found := s.waiting[nodeId]; !found {
+		return
+	}
+	delete(s.waiting, nodeId)
+	if err != nil {
+		s.failures[string(nodeId)] = err
+	} else if reply != nil {
+		s.bodies = append(s.bodies, reply.Body)
+	}
+	s.finishIfComplete()
+}
+
+// seal ends the expect calls; the survey finishes with err, if not nil,
+// or once every expected node answered.
+func (s *survey) seal(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sealed = true
+	s.err = err
+	s.finishIfComplete()
+}
+
+// expire fails the nodes that have not answered yet with err.
+// It returns false if the survey had already finished.
+func (s *survey) expire(err error) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.done == nil {
+		return false
+	}
+	for nodeId := range s.waiting {
+		s.failures[string(nodeId)] = err
+		delete(s.waiting, nodeId)
+	}
+	if !s.sealed {
+		s.sealed, s.err = true, err
+	}
+	s.finishIfComplete()
+	return true
+}
+
+func (s *survey) finishIfComplete() {
+	if !s.sealed || len(s.waiting) > 0 || s.done == nil {
+		return
+	}
+	done := s.done
+	s.done = nil
+	switch {
+	case s.err != nil:
+		done(nil, s.err)
+	case len(s.failures) > 0:
+		done(s.bodies, &BroadcastError{Errors: s.failures})
+	default:
+		done(s.bodies, nil)
+	}
+}
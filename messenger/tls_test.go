@@ -0,0 +1,104 @@
+package messenger
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMutualTLS(t *testing.T) {
+	log.Println("---------------- TestMutualTLS ----------------")
+
+	tlsConfig, err := newTestTLSConfig()
+	if err != nil {
+		t.Fatalf("Failed to create certificates: %v", err)
+	}
+
+	var mutex sync.Mutex
+	identities := map[string]string{}
+	opts := Options{
+		TLSConfig: tlsConfig,
+		VerifyPeer: func(peerId string, certs []*x509.Certificate) error {
+			if len(certs) == 0 {
+				return fmt.Errorf("no certificate presented by %s", peerId)
+			}
+			mutex.Lock()
+			identities[peerId] = certs[0].Subject.CommonName
+			mutex.Unlock()
+			return nil
+		},
+	}
+
+	server, err := NewMessengerWithOptions("localhost:50000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer server.Leave()
+	server.Subscribe("job", echo)
+	server.Join()
+
+	client, err := NewMessengerWithOptions("localhost:40000", opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	reply, _, err := client.Request("job", []byte("Hello"))
+	if err != nil {
+		t.Fatalf("Request returned error: %s", err)
+	}
+	if string(reply) != "Hello" {
+		t.Fatalf("Expected: 'Hello'; received '%s'", string(reply))
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if identities["127.0.0.1:50000"] != "envoy-test" || identities["127.0.0.1:40000"] != "envoy-test" {
+		t.Fatalf("Peer identities were not verified: %v", identities)
+	}
+}
+
+func newTestTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "envoy-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
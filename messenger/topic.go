@@ -0,0 +1,89 @@
+package messenger
+
+import (
+	"sort"
+	"strings"
+)
+
+// Topics are dot-separated tokens, e.g. "orders.eu.create".
+// Subscriptions may use wildcards: "*" matches exactly one token and
+// a trailing ">" matches one or more tokens, so both "orders.*.create"
+// and "orders.>" match "orders.eu.create".
+const (
+	topicSeparator = "."
+	singleWildcard = "*"
+	tailWildcard   = ">"
+)
+
+func (pattern topic) matches(t topic) bool {
+	if pattern == t {
+		return true
+	}
+	patternTokens := strings.Split(string(pattern), topicSeparator)
+	tokens := strings.Split(string(t), topicSeparator)
+	for i, pt := range patternTokens {
+		if pt == tailWildcard && i == len(patternTokens)-1 {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) {
+			return false
+		}
+		if pt != singleWildcard && pt != tokens[i] {
+			return false
+		}
+	}
+	return len(patternTokens) == len(tokens)
+}
+
+// isMoreSpecificThan compares two patterns token by token:
+// a literal token beats "*", which beats ">".
+func (pattern topic) isMoreSpecificThan(other topic) bool {
+	patternTokens := strings.Split(string(pattern), topicSeparator)
+	otherTokens := strings.Split(string(other), topicSeparator)
+	for i := 0; i < len(patternTokens) && i < len(otherTokens); i++ {
+		pr, or := tokenRank(patternTokens[i]), tokenRank(otherTokens[i])
+		if pr != or {
+			return pr > or
+		}
+	}
+	return len(patternTokens) > len(otherTokens)
+}
+
+func tokenRank(token string) int {
+	switch token {
+	case tailWildcard:
+		return 0
+	case singleWildcard:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// bestMatch returns the most specific pattern among patterns matching t.
+func bestMatch[V any](patterns map[topic]V, t topic) (topic, bool) {
+	if _, found := patterns[t]; found {
+		return t, true
+	}
+	var best topic
+	found := false
+	for pattern := range patterns {
+		if pattern.matches(t) && (!found || pattern.isMoreSpecificThan(best)) {
+			best, found = pattern, true
+		}
+	}
+	return best, found
+}
+
+// matchingGroups returns the groups with a pattern matching t in name order,
+// so the default group comes first.
+func matchingGroups[V any](groups map[group]map[topic]V, t topic) []group {
+	result := []group{}
+	for g, patterns := range groups {
+		if _, found := bestMatch(patterns, t); found {
+			result = append(result, g)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
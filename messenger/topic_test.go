@@ -0,0 +1,83 @@
+package messenger
+
+import (
+	"log"
+	"testing"
+)
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		pattern, topic topic
+		matches        bool
+	}{
+		{"orders.eu.create", "orders.eu.create", true},
+		{"orders.eu.create", "orders.us.create", false},
+		{"orders.*.create", "orders.eu.create", true},
+		{"orders.*.create", "orders.eu.cancel", false},
+		{"orders.*", "orders.eu.create", false},
+		{"orders.>", "orders.eu.create", true},
+		{"orders.>", "orders", false},
+		{">", "orders", true},
+		{"*.*.create", "orders.eu.create", true},
+	}
+	for _, c := range cases {
+		if c.pattern.matches(c.topic) != c.matches {
+			t.Errorf("'%s'.matches('%s') != %v", c.pattern, c.topic, c.matches)
+		}
+	}
+}
+
+func TestTopicSpecificity(t *testing.T) {
+	patterns := map[topic]struct{}{"orders.>": {}, "orders.*.create": {}, ">": {}}
+	if best, _ := bestMatch(patterns, "orders.eu.create"); best != "orders.*.create" {
+		t.Errorf("Expected 'orders.*.create'; received '%s'", best)
+	}
+	patterns["orders.eu.create"] = struct{}{}
+	if best, _ := bestMatch(patterns, "orders.eu.create"); best != "orders.eu.create" {
+		t.Errorf("Expected 'orders.eu.create'; received '%s'", best)
+	}
+	if best, _ := bestMatch(patterns, "orders.eu.cancel"); best != "orders.>" {
+		t.Errorf("Expected 'orders.>'; received '%s'", best)
+	}
+	if best, _ := bestMatch(patterns, "billing"); best != ">" {
+		t.Errorf("Expected '>'; received '%s'", best)
+	}
+}
+
+func TestWildcardRouting(t *testing.T) {
+	log.Println("---------------- TestWildcardRouting ----------------")
+
+	server1, err := NewMessenger("localhost:50000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server1.Leave()
+	server1.Subscribe("orders.>", echo1)
+	server1.Join()
+
+	server2, err := NewMessenger("localhost:50001")
+	if err != nil {
+		t.FailNow()
+	}
+	defer server2.Leave()
+	server2.Subscribe("orders.*.create", echo2)
+	server2.Join("localhost:50000")
+
+	client, err := NewMessenger("localhost:40000")
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000", "localhost:50001")
+
+	for i := 0; i < 10; i++ {
+		reply, _, err := client.Request("orders.eu.create", []byte("Hello"))
+		if err != nil || string(reply) != "server:2 Hello" {
+			t.Fatalf("Expected: 'server:2 Hello'; received '%s'; err = %v", string(reply), err)
+		}
+		reply, _, err = client.Request("orders.eu.cancel", []byte("Hello"))
+		if err != nil || string(reply) != "server:1 Hello" {
+			t.Fatalf("Expected: 'server:1 Hello'; received '%s'; err = %v", string(reply), err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package messenger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var InvalidTraceparentError = errors.New("invalid traceparent")
+
+type (
+	TraceId [16]byte
+	SpanId  [8]byte
+)
+
+// SpanContext identifies a span within a trace. It travels with every
+// message in the W3C traceparent format.
+type SpanContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Sampled bool
+}
+
+// IsValid reports whether sc has both ids set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId != TraceId{} && sc.SpanId != SpanId{}
+}
+
+// String renders sc as a traceparent: 00-<trace id>-<span id>-<flags>.
+func (sc SpanContext) String() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceId[:]), hex.EncodeToString(sc.SpanId[:]), flags)
+}
+
+// ParseTraceparent parses a version 00 traceparent.
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+	if len(traceparent) != 55 || traceparent[:3] != "00-" || traceparent[35] != '-' || traceparent[52] != '-' {
+		return sc, InvalidTraceparentError
+	}
+	if _, err := hex.Decode(sc.TraceId[:], []byte(traceparent[3:35])); err != nil {
+		return sc, InvalidTraceparentError
+	}
+	if _, err := hex.Decode(sc.SpanId[:], []byte(traceparent[36:52])); err != nil {
+		return sc, InvalidTraceparentError
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(traceparent[53:])); err != nil {
+		return sc, InvalidTraceparentError
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, InvalidTraceparentError
+	}
+	return sc, nil
+}
+
+// Tracer starts the spans the messenger opens around sending a message
+// and around running a handler. Plug in an adapter to your tracing system
+// through Options.Tracer.
+type Tracer interface {
+	// Start starts a span named name. The parent is invalid for a new trace.
+	Start(name string, parent SpanContext) Span
+}
+
+type Span interface {
+	Context() SpanContext
+	SetAttribute(key, value string)
+	// RecordError marks the span as failed; it is not called for successful spans.
+	RecordError(err error)
+	End()
+}
+
+// NewSpanContext returns the context of a new child of parent,
+// or of a new trace's root span if parent is invalid.
+func NewSpanContext(parent SpanContext) SpanContext {
+	sc := parent
+	if !parent.IsValid() {
+		rand.Read(sc.TraceId[:])
+		sc.Sampled = true
+	}
+	rand.Read(sc.SpanId[:])
+	return sc
+}
+
+// propagatingTracer records nothing; it only keeps trace ids flowing
+// between nodes when no Tracer is configured.
+type propagatingTracer struct{}
+
+func (propagatingTracer) Start(_ string, parent SpanContext) Span {
+	return propagatingSpan(NewSpanContext(parent))
+}
+
+type propagatingSpan SpanContext
+
+func (span propagatingSpan) Context() SpanContext { return SpanContext(span) }
+func (propagatingSpan) SetAttribute(_, _ string)  {}
+func (propagatingSpan) RecordError(error)         {}
+func (propagatingSpan) End()                      {}
+
+type traceKey struct{}
+
+// WithTrace returns a context whose messages continue the trace of sc.
+// Pass a handler's msg.Trace to continue its trace in downstream requests.
+func WithTrace(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, sc)
+}
+
+// TraceFromContext returns the span context set by WithTrace, if any.
+func TraceFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(traceKey{}).(SpanContext)
+	return sc
+}
+
+// startSendSpan starts the span around sending msg and stamps msg with it.
+func (msgr *messenger) startSendSpan(ctx context.Context, msg *message) Span {
+	span := msgr.Tracer.Start("envoy.send "+string(msg.Topic), TraceFromContext(ctx))
+	span.SetAttribute("envoy.topic", string(msg.Topic))
+	span.SetAttribute("envoy.message_type", msg.MessageType.String())
+	span.SetAttribute("envoy.message_id", msg.MessageId.String())
+	msg.TraceParent = span.Context().String()
+	return span
+}
+
+// startHandlerSpan starts the span around handling msg and exposes it to the handler as in.Trace.
+func (msgr *messenger) startHandlerSpan(msg *message, in *Message) Span {
+	parent, _ := ParseTraceparent(msg.TraceParent)
+	span := msgr.Tracer.Start("envoy.handle "+string(msg.Topic), parent)
+	span.SetAttribute("envoy.topic", string(msg.Topic))
+	span.SetAttribute("envoy.message_type", msg.MessageType.String())
+	span.SetAttribute("envoy.message_id", msg.MessageId.String())
+	in.Trace = span.Context()
+	return span
+}
+
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,113 @@
+package messenger
+
+import (
+	"context"
+	"log"
+	"sync"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	sc := NewSpanContext(SpanContext{})
+	parsed, err := ParseTraceparent(sc.String())
+	if err != nil || parsed != sc {
+		t.Fatalf("Expected %v; received %v; err = %v", sc, parsed, err)
+	}
+	for _, invalid := range []string{
+		"",
+		"00-00000000000000000000000000000000-0000000000000000-01",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+	} {
+		if _, err := ParseTraceparent(invalid); err != InvalidTraceparentError {
+			t.Errorf("Expected InvalidTraceparentError for %q; received %v", invalid, err)
+		}
+	}
+}
+
+type recordedSpan struct {
+	name   string
+	parent SpanContext
+	SpanContext
+}
+
+type recordingTracer struct {
+	sync.Mutex
+	spans []*recordedSpan
+}
+
+func (tracer *recordingTracer) Start(name string, parent SpanContext) Span {
+	tracer.Lock()
+	defer tracer.Unlock()
+	span := &recordedSpan{name: name, parent: parent, SpanContext: NewSpanContext(parent)}
+	tracer.spans = append(tracer.spans, span)
+	return span
+}
+
+func (span *recordedSpan) Context() SpanContext     { return span.SpanContext }
+func (span *recordedSpan) SetAttribute(_, _ string) {}
+func (span *recordedSpan) RecordError(error)        {}
+func (span *recordedSpan) End()                     {}
+
+func (tracer *recordingTracer) find(name string) *recordedSpan {
+	tracer.Lock()
+	defer tracer.Unlock()
+	for _, span := range tracer.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracePropagation(t *testing.T) {
+	log.Println("---------------- TestTracePropagation ----------------")
+
+	tracer := &recordingTracer{}
+	backend, err := NewMessengerWithOptions("localhost:50001", Options{Tracer: tracer})
+	if err != nil {
+		t.FailNow()
+	}
+	defer backend.Leave()
+	backend.SubscribeMessages("backend", "", func(msg *Message) ([]byte, error) {
+		return msg.Body, nil
+	})
+	backend.Join()
+
+	frontend, err := NewMessengerWithOptions("localhost:50000", Options{Tracer: tracer})
+	if err != nil {
+		t.FailNow()
+	}
+	defer frontend.Leave()
+	frontend.SubscribeMessages("frontend", "", func(msg *Message) ([]byte, error) {
+		reply, _, err := frontend.RequestContext(WithTrace(context.Background(), msg.Trace), "backend", msg.Body)
+		return reply, err
+	})
+	frontend.Join("localhost:50001")
+
+	client, err := NewMessengerWithOptions("localhost:40000", Options{Tracer: tracer})
+	if err != nil {
+		t.FailNow()
+	}
+	defer client.Leave()
+	client.Join("localhost:50000")
+
+	root := NewSpanContext(SpanContext{})
+	reply, _, err := client.RequestContext(WithTrace(context.Background(), root), "frontend", []byte("Hello"))
+	if err != nil || string(reply) != "Hello" {
+		t.Fatalf("Expected: 'Hello'; received '%s'; err = %v", string(reply), err)
+	}
+
+	chain := []string{"envoy.send frontend", "envoy.handle frontend", "envoy.send backend", "envoy.handle backend"}
+	parent := root
+	for _, name := range chain {
+		span := tracer.find(name)
+		if span == nil {
+			t.Fatalf("Span %q was not recorded", name)
+		}
+		if span.parent != parent || span.TraceId != root.TraceId {
+			t.Fatalf("Span %q: expected parent %v; received %v", name, parent, span.parent)
+		}
+		parent = span.SpanContext
+	}
+}
@@ -3,36 +3,134 @@ package messenger
 import (
 	"github.com/andrew-suprun/envoy/actor"
 	"net"
+	"time"
 )
 
 type writer struct {
 	name string
 	hostId
-	actor.Actor
+	actor.TypedActor[writeRequest]
 	net.Conn
-	msgr actor.Actor
+	codec      Codec
+	compressor Compressor
+	msgr       actor.TypedActor[msgrEvent]
+	multipart  bool           // the peer reassembles parts
+	parts      []writeRequest // large messages with parts left to write, in turn
+	wakeup     bool           // an empty writeRequest is queued to write the next part
+	*Options
 }
 
-func newWriter(name string, hostId hostId, conn net.Conn, msgr actor.Actor) actor.Actor {
+// writeRequest asks the writer to write msg.Body from offset on;
+// offsets above zero continue a multi-part message. A request without
+// a message only makes the writer write the next part.
+type writeRequest struct {
+	msg    *message
+	offset int
+}
+
+func newWriter(name string, hostId hostId, conn net.Conn, codec Codec, compressor Compressor, msgr actor.TypedActor[msgrEvent], multipart bool, supervisor actor.Supervisor, opts *Options) actor.TypedActor[writeRequest] {
 	writer := &writer{
-		name:   name,
-		hostId: hostId,
-		Actor:  actor.NewActor(name),
-		Conn:   conn,
-		msgr:   msgr,
+		name:       name,
+		hostId:     hostId,
+		Conn:       conn,
+		codec:      codec,
+		compressor: compressor,
+		msgr:       msgr,
+		multipart:  multipart,
+		Options:    opts,
 	}
 
-	return writer.
-		RegisterHandler("write", writer.handleWrite).
+	writer.TypedActor = actor.NewTypedActor(name, writer.handleWrite,
+		actor.Mailbox(opts.WriteQueueSize, actor.Block), actor.Supervise(supervisor)).
 		Start()
+	return writer
+}
+
+func (writer *writer) handleWrite(req writeRequest) {
+	msg := req.msg
+	if msg == nil {
+		writer.wakeup = false
+	} else if writer.multipart && len(msg.Body) > writer.PartSize {
+		writer.parts = append(writer.parts, req)
+	} else {
+		err := writer.writeFrame(msg)
+		writer.msgr.Send(writeResultEvent{peerId: writer.hostId, msg: msg, err: err})
+	}
+	writer.writeNextPart()
+}
+
+// writeNextPart writes one part of the large message next in turn. Every frame
+// taken off the mailbox is followed by at most one part, so messages sent in
+// the meantime are not stuck behind large ones.
+func (writer *writer) writeNextPart() {
+	if len(writer.parts) == 0 {
+		return
+	}
+	req := writer.parts[0]
+	writer.parts = writer.parts[1:]
+	if next, more := writer.writePart(req); more {
+		// Take turns among the messages already started only, so that the
+		// peer never has more than maxPartialMessages to reassemble.
+		i := len(writer.parts)
+		if i > maxPartialMessages-1 {
+			i = maxPartialMessages - 1
+		}
+		writer.parts = append(writer.parts[:i], append([]writeRequest{next}, writer.parts[i:]...)...)
+	}
+	if len(writer.parts) > 0 && !writer.wakeup {
+		// When the mailbox is full the writer runs again anyway.
+		writer.wakeup = writer.TrySend(writeRequest{}) == nil
+	}
 }
 
-func (writer *writer) handleWrite(_ string, info []interface{}) {
-	msg := info[0].(*message)
-	err := writeMessage(writer.Conn, msg)
-	writer.msgr.Send("write-result", writer.hostId, msg, err)
+// writePart writes the part of req.msg at req.offset and returns the request
+// for the next part, if any.
+func (writer *writer) writePart(req writeRequest) (next writeRequest, more bool) {
+	msg, offset := req.msg, req.offset
+	end := offset + writer.PartSize
+	if end > len(msg.Body) {
+		end = len(msg.Body)
+	}
+	part := &message{
+		MessageId:   msg.MessageId,
+		MessageType: msg.MessageType,
+		Body:        msg.Body[offset:end],
+		More:        end < len(msg.Body),
+	}
+	if offset == 0 {
+		part.Topic = msg.Topic
+		part.Groups = msg.Groups
+		part.Headers = msg.Headers
+		part.TraceParent = msg.TraceParent
+	}
+	err := writer.writeFrame(part)
+	if err != nil || !part.More {
+		writer.msgr.Send(writeResultEvent{peerId: writer.hostId, msg: msg, err: err})
+		return writeRequest{}, false
+	}
+	return writeRequest{msg: msg, offset: end}, true
+}
+
+// writeFrame writes msg, compressing its body when the connection has a
+// compressor and the body is large enough to be worth it.
+func (writer *writer) writeFrame(msg *message) error {
+	if writer.compressor != nil && len(msg.Body) >= writer.CompressionThreshold {
+		body, err := writer.compressor.Compress(msg.Body)
+		if err != nil {
+			return err
+		}
+		if len(body) < len(msg.Body) {
+			compressed := *msg
+			compressed.Body, compressed.Compressed = body, true
+			msg = &compressed
+		}
+	}
+	if writer.WriteTimeout > 0 {
+		writer.Conn.SetWriteDeadline(time.Now().Add(writer.WriteTimeout))
+	}
+	return writeMessage(writer.Conn, msg, writer.codec)
 }
 
 func (writer *writer) logf(format string, params ...interface{}) {
-	Log.Debugf(">>> %s: "+format, append([]interface{}{writer.name}, params...)...)
+	writer.Log.Debugf(">>> %s: "+format, append([]interface{}{writer.name}, params...)...)
 }